@@ -0,0 +1,617 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/analog-substance/copper/pkg/lib"
+	"github.com/schollz/progressbar/v3"
+	"github.com/spf13/cobra"
+	"io"
+	"iter"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"time"
+)
+
+// addDiscoveryFlags registers the flags shared by every command that runs host
+// discovery. privilegedICMP is left to each command since its shorthand
+// clashes with the port spec flag of the ports command.
+func addDiscoveryFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolP("verbose", "v", false, "Print active hosts as they are found. Machine readable output also includes hosts that are down")
+	cmd.Flags().StringP("output-format", "o", "text", fmt.Sprintf("Output format. Available: text,%s", strings.Join(lib.OutputFormats, ",")))
+	cmd.Flags().IntP("icmp-timeout", "i", 500, "ICMP timeout in milliseconds. To disable ICMP checks set to 0.")
+	cmd.Flags().IntP("tcp-timeout", "t", 500, "TCP timeout in milliseconds.  To disable TCP checks set to 0.")
+	cmd.Flags().IntP("tcp-ports", "T", 100, "Number of TCP ports to check")
+	cmd.Flags().Int("udp-timeout", 1000, "UDP timeout in milliseconds. To disable UDP checks set to 0.")
+	cmd.Flags().IntP("udp-ports", "U", 20, "Number of UDP ports to check when the udp method is enabled")
+	cmd.Flags().String("probe-ports", "", "Ports to probe during discovery, e.g. top:50,3389,U:53,161. Overrides --tcp-ports and --udp-ports")
+	cmd.Flags().Int("arp-timeout", 500, "ARP and NDP timeout in milliseconds for on-link hosts. To disable ARP checks set to 0.")
+	cmd.Flags().Int("sweep-timeout", 2000, "How long to wait for replies when sweeping IPv6 prefixes too large to enumerate")
+	cmd.Flags().Bool("sweep-excluded", false, "Sweep IPv6 prefixes that contain excluded hosts, which the sweep will also reach")
+	cmd.Flags().IntP("workers", "w", lib.DefaultWorkers, "Number of hosts to check at once")
+	cmd.Flags().Bool("syn", false, "Send raw TCP SYNs instead of connecting, for TCP discovery and port scans. Needs root or CAP_NET_RAW")
+	cmd.Flags().Bool("adaptive", false, "Adapt ICMP and TCP timeouts to observed round trip times, starting from --icmp-timeout and --tcp-timeout")
+	cmd.Flags().Int("min-timeout", 100, "Lowest timeout in milliseconds --adaptive will use")
+	cmd.Flags().Int("max-timeout", 3000, "Highest timeout in milliseconds --adaptive will use")
+	cmd.Flags().Int("rate", 0, "Maximum ICMP and TCP probes per second across all hosts. 0 is unlimited")
+	cmd.Flags().Int("subnet-rate", 0, "Maximum ICMP and TCP probes per second to any single /24, or /64 for IPv6. 0 is unlimited")
+	cmd.Flags().Int("max-conns", 0, "Maximum number of probes and connections in flight across all hosts. 0 is unlimited")
+	cmd.Flags().IntP("attempts", "a", 1, "Number of attempts per host")
+	cmd.Flags().StringP("file", "f", "scope.txt", "File with scope to check")
+	cmd.Flags().String("input-format", lib.InputAuto, fmt.Sprintf("Format of the scope file. Available: %s", strings.Join(lib.InputFormats, ",")))
+	cmd.Flags().StringSliceP("exclude", "x", nil, "IPs, CIDRs, ranges or hostnames that must never be probed")
+	cmd.Flags().String("exclude-file", "", "File with entries that must never be probed, one per line")
+	cmd.Flags().Int("max-hosts", 1<<20, "Refuse to run when the scope expands to more hosts than this. 0 is unlimited")
+	cmd.Flags().Bool("dry-run", false, "Print how many hosts are in scope and exit")
+	cmd.Flags().String("state-file", "", "File that records every finished host so an interrupted scan can be resumed")
+	cmd.Flags().Bool("resume", false, "Skip hosts already finished in --state-file and carry on where it left off")
+	cmd.Flags().String("wordlist", "", "File of subdomains used to expand *.domain scope entries. Only names that resolve are probed")
+	cmd.Flags().String("resolver", "", "DNS server used to resolve hostnames in scope, as ip or ip:port. Defaults to the system resolver")
+	cmd.Flags().Int("resolve-concurrency", lib.DefaultResolveConcurrency, "Number of hostnames to resolve at once")
+	cmd.Flags().Int("resolve-timeout", lib.DefaultResolveTimeoutMillis, "Hostname lookup timeout in milliseconds")
+	cmd.Flags().StringSliceP("methods", "m", lib.DefaultMethods, fmt.Sprintf("Discovery methods to use, in order. Available: %s", strings.Join(lib.ProberNames(), ",")))
+}
+
+// addScanFlags registers the flags shared by every command that scans ports.
+func addScanFlags(cmd *cobra.Command) {
+	cmd.Flags().Int("port-concurrency", lib.DefaultPortConcurrency, "Number of ports to dial at once on a single host")
+}
+
+// discoveryRun is the pipeline shared by every command that runs discovery:
+// the result writer, scope, state file, shared probing and the tally of
+// results that is reported once discovery ends.
+type discoveryRun struct {
+	start      time.Time
+	ctx        context.Context
+	stop       context.CancelFunc
+	writer     lib.ResultWriter
+	scope      *lib.Scope
+	state      *lib.StateFile
+	probing    *sharedProbing
+	discoverer *lib.Discoverer
+	bar        *progressbar.ProgressBar
+
+	checked    int
+	excluded   int
+	unresolved []lib.HostResult
+}
+
+// newDiscoveryRun sets up discovery from the flags. Like loadScope it returns
+// nil when there is nothing to run, and otherwise the run must be closed once
+// its results are handled.
+func newDiscoveryRun(cmd *cobra.Command) (*discoveryRun, error) {
+	writer, err := newResultWriter(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	// installed first so Ctrl-C also stops lookups while loading the scope
+	ctx, stop := interruptContext()
+	r := &discoveryRun{start: time.Now(), ctx: ctx, stop: stop, writer: writer}
+
+	if r.scope, err = loadScope(ctx, cmd); err != nil || r.scope == nil {
+		r.close()
+		return nil, err
+	}
+	if r.state, err = openStateFile(cmd); err != nil {
+		r.close()
+		return nil, err
+	}
+	if r.probing, err = newSharedProbing(cmd, true); err != nil {
+		r.close()
+		return nil, err
+	}
+	if r.discoverer, err = newDiscoverer(cmd, r.state, r.probing); err != nil {
+		r.close()
+		return nil, err
+	}
+
+	r.bar = newProgressBar(r.scope)
+	r.excluded = r.scope.ExcludedCount()
+	return r, nil
+}
+
+// results yields the results of the run being resumed, then every result
+// from discovery, telling them apart by the resumed flag.
+func (r *discoveryRun) results() iter.Seq2[lib.HostResult, bool] {
+	return func(yield func(lib.HostResult, bool) bool) {
+		for _, result := range resumedResults(r.state) {
+			if !yield(result, true) {
+				return
+			}
+		}
+		for result := range r.discoverer.Discover(r.ctx, r.scope) {
+			if !yield(result, false) {
+				return
+			}
+		}
+	}
+}
+
+// tally advances the progress bar and counts result, reporting whether it is
+// a host that was checked rather than excluded or unresolved.
+func (r *discoveryRun) tally(result lib.HostResult) bool {
+	advance(r.bar)
+
+	if result.Reason == lib.ReasonExcluded {
+		r.excluded++
+		return false
+	}
+	if isUnresolved(result) {
+		r.unresolved = append(r.unresolved, result)
+		return false
+	}
+
+	r.checked++
+	return true
+}
+
+// finish closes the writer and reports the run on stderr. active describes
+// the active hosts found, for the summary line.
+func (r *discoveryRun) finish(active string) {
+	if r.writer != nil {
+		closeResultWriter(r.writer)
+	}
+
+	reportUnresolved(r.unresolved)
+
+	reportInterrupted(r.ctx, r.state)
+
+	duration := time.Since(r.start)
+	fmt.Fprintf(os.Stderr, "Checked %d hosts, %s, %d excluded. Took %s\n", r.checked, active, r.excluded, duration)
+}
+
+func (r *discoveryRun) close() {
+	if r.probing != nil {
+		r.probing.Close()
+	}
+	if r.state != nil {
+		r.state.Close()
+	}
+	if r.stop != nil {
+		r.stop()
+	}
+}
+
+// largeScope is the host count above which a warning is printed before
+// discovery starts.
+const largeScope = 1 << 16
+
+// loadScope reads the scope file and reports its size on stderr. It returns a
+// nil scope when there is nothing to do, either because of --dry-run or
+// because the scope exceeds --max-hosts. Hostnames in exclusions and
+// wildcards are resolved with --resolver under ctx.
+func loadScope(ctx context.Context, cmd *cobra.Command) (*lib.Scope, error) {
+	scopeFile, _ := cmd.Flags().GetString("file")
+	inputFormat, _ := cmd.Flags().GetString("input-format")
+	maxHosts, _ := cmd.Flags().GetInt("max-hosts")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	excludes, _ := cmd.Flags().GetStringSlice("exclude")
+	excludeFile, _ := cmd.Flags().GetString("exclude-file")
+	wordlist, _ := cmd.Flags().GetString("wordlist")
+	sweepExcluded, _ := cmd.Flags().GetBool("sweep-excluded")
+
+	scope, err := readScope(scopeFile, inputFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	if excludeFile != "" {
+		fileExcludes, err := readListFile("exclude", excludeFile)
+		if err != nil {
+			return nil, err
+		}
+		excludes = append(excludes, fileExcludes...)
+	}
+
+	resolver := newResolver(cmd)
+	for _, exclude := range excludes {
+		if err := scope.Exclude(ctx, resolver, exclude); err != nil {
+			return nil, fmt.Errorf("unable to exclude %q: %w", exclude, err)
+		}
+	}
+
+	prefixes := scope.SweepPrefixes(sweepExcluded)
+	for _, prefix := range scope.Prefixes {
+		if !slices.Contains(prefixes, prefix) {
+			fmt.Fprintf(os.Stderr, "Not sweeping %s, it contains excluded hosts. Use --sweep-excluded to sweep it anyway\n", prefix)
+		}
+	}
+
+	if len(scope.Wildcards) > 0 {
+		if wordlist == "" {
+			fmt.Fprintf(os.Stderr, "Skipping wildcard entries, use --wordlist to expand them: *.%s\n", strings.Join(scope.Wildcards, ", *."))
+		} else {
+			words, err := readListFile("wordlist", wordlist)
+			if err != nil {
+				return nil, err
+			}
+
+			added := scope.ExpandWildcards(ctx, resolver, words)
+			for _, domain := range scope.Wildcards {
+				fmt.Fprintf(os.Stderr, "Expanded *.%s into %d hosts\n", domain, added[domain])
+			}
+		}
+	}
+
+	count := scope.Count()
+	fmt.Fprintf(os.Stderr, "Scope contains %d hosts", count)
+	if scope.HasExclusions() {
+		fmt.Fprintf(os.Stderr, " after excluding %d", scope.ExcludedCount())
+	}
+	if len(prefixes) > 0 {
+		fmt.Fprintf(os.Stderr, " and %d prefixes to sweep", len(prefixes))
+	}
+	fmt.Fprintln(os.Stderr)
+
+	if dryRun {
+		return nil, nil
+	}
+
+	if maxHosts > 0 && count > maxHosts {
+		return nil, fmt.Errorf("scope contains %d hosts, more than --max-hosts %d", count, maxHosts)
+	}
+
+	if count > largeScope {
+		fmt.Fprintf(os.Stderr, "Warning: scope is larger than %d hosts\n", largeScope)
+	}
+
+	return scope, nil
+}
+
+// readListFile reads one entry per line, ignoring blank lines and comments.
+func readListFile(kind string, path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %s file: %s", kind, path)
+	}
+	defer f.Close()
+
+	lines := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines, scanner.Err()
+}
+
+func readScope(scopeFile string, inputFormat string) (*lib.Scope, error) {
+	var scopeReader io.Reader
+	if scopeFile == "-" {
+		scopeReader = os.Stdin
+	} else {
+		f, err := os.Open(scopeFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open scope file: %s", scopeFile)
+		}
+		defer f.Close()
+		scopeReader = f
+	}
+
+	scope, err := lib.ImportScope(scopeReader, inputFormat)
+	if scope == nil {
+		return nil, err
+	}
+	if err != nil {
+		// bad entries are reported but don't stop the rest of the scope
+		fmt.Fprintln(os.Stderr, err)
+	}
+
+	return scope, nil
+}
+
+func newDiscoverer(cmd *cobra.Command, state *lib.StateFile, probing *sharedProbing) (*lib.Discoverer, error) {
+	timeoutICMP, _ := cmd.Flags().GetInt("icmp-timeout")
+	timeoutTCP, _ := cmd.Flags().GetInt("tcp-timeout")
+	tcpPortCount, _ := cmd.Flags().GetInt("tcp-ports")
+	timeoutUDP, _ := cmd.Flags().GetInt("udp-timeout")
+	udpPortCount, _ := cmd.Flags().GetInt("udp-ports")
+	timeoutARP, _ := cmd.Flags().GetInt("arp-timeout")
+	timeoutSweep, _ := cmd.Flags().GetInt("sweep-timeout")
+	sweepExcluded, _ := cmd.Flags().GetBool("sweep-excluded")
+	attempts, _ := cmd.Flags().GetInt("attempts")
+	workerCount, _ := cmd.Flags().GetInt("workers")
+	privilegedICMP, _ := cmd.Flags().GetBool("privilegedICMP")
+	methods, _ := cmd.Flags().GetStringSlice("methods")
+	probePorts, _ := cmd.Flags().GetString("probe-ports")
+
+	// a zero timeout disables every method that uses it
+	timeouts := map[string]int{
+		lib.MethodICMP:      timeoutICMP,
+		lib.MethodTimestamp: timeoutICMP,
+		lib.MethodMask:      timeoutICMP,
+		lib.MethodProto:     timeoutICMP,
+		lib.MethodTCP:       timeoutTCP,
+		lib.MethodACK:       timeoutTCP,
+		lib.MethodUDP:       timeoutUDP,
+		lib.MethodARP:       timeoutARP,
+		lib.MethodNDP:       timeoutARP,
+	}
+
+	enabled := []string{}
+	for _, method := range methods {
+		if timeout, ok := timeouts[method]; ok && timeout == 0 {
+			continue
+		}
+		enabled = append(enabled, method)
+	}
+
+	if len(enabled) == 0 {
+		return nil, fmt.Errorf("no discovery methods enabled")
+	}
+
+	proberConfig := lib.ProberConfig{
+		TimeoutICMPMillis:  timeoutICMP,
+		TimeoutTCPMillis:   timeoutTCP,
+		TimeoutUDPMillis:   timeoutUDP,
+		TimeoutARPMillis:   timeoutARP,
+		TimeoutSweepMillis: timeoutSweep,
+		TCPPorts:           lib.GetTopPopularPorts("tcp", tcpPortCount),
+		UDPPorts:           lib.GetTopPopularPorts("udp", udpPortCount),
+		PrivilegedICMP:     privilegedICMP,
+		Pacer:              probing.pacer,
+		RTT:                probing.rtt,
+		SYN:                probing.syn,
+		ICMP:               probing.icmp,
+	}
+
+	if probePorts != "" {
+		spec, err := lib.ParsePortSpec(probePorts)
+		if err != nil {
+			return nil, err
+		}
+		proberConfig.TCPPorts = spec.TCP
+		proberConfig.UDPPorts = spec.UDP
+	}
+	probers, err := lib.NewProbers(enabled, proberConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := lib.Options{
+		Workers:  workerCount,
+		Attempts: attempts,
+		Probers:  probers,
+		Sweepers: []lib.Sweeper{lib.NewNeighborSweeper(proberConfig)},
+		Resolver: newResolver(cmd),
+		SweepError: func(sweeper string, err error) {
+			fmt.Fprintf(os.Stderr, "Warning: %s sweep incomplete: %v\n", sweeper, err)
+		},
+		SweepExcluded: sweepExcluded,
+	}
+	if state != nil {
+		opts.Skip = func(target lib.Target) bool {
+			return state.Done(target.Host)
+		}
+	}
+
+	return lib.NewDiscoverer(opts), nil
+}
+
+// sharedProbing is the state discovery and port scanning share, so rate limits,
+// round trip estimates and raw sockets carry across both.
+type sharedProbing struct {
+	budget *lib.ConnBudget
+	pacer  *lib.Pacer
+	rtt    *lib.RTTEstimator
+	syn    *lib.SYNScanner
+	icmp   *lib.ICMPSweeper
+}
+
+// newSharedProbing sets up the shared state from the flags. The shared ICMP
+// socket is only opened for discovery with ICMP enabled, and pinging falls
+// back to a socket per host when it can't be.
+func newSharedProbing(cmd *cobra.Command, discovery bool) (*sharedProbing, error) {
+	rate, _ := cmd.Flags().GetInt("rate")
+	subnetRate, _ := cmd.Flags().GetInt("subnet-rate")
+	maxConns, _ := cmd.Flags().GetInt("max-conns")
+	adaptive, _ := cmd.Flags().GetBool("adaptive")
+	minTimeout, _ := cmd.Flags().GetInt("min-timeout")
+	maxTimeout, _ := cmd.Flags().GetInt("max-timeout")
+	syn, _ := cmd.Flags().GetBool("syn")
+	timeoutICMP, _ := cmd.Flags().GetInt("icmp-timeout")
+	methods, _ := cmd.Flags().GetStringSlice("methods")
+	privilegedICMP, _ := cmd.Flags().GetBool("privilegedICMP")
+
+	p := &sharedProbing{budget: lib.NewConnBudget(maxConns)}
+	p.pacer = lib.NewPacer(rate, subnetRate, p.budget)
+	if adaptive {
+		p.rtt = lib.NewRTTEstimator(minTimeout, maxTimeout)
+	}
+
+	if syn {
+		scanner, err := lib.NewSYNScanner(p.pacer)
+		if err != nil {
+			return nil, err
+		}
+		p.syn = scanner
+	}
+
+	if discovery && timeoutICMP > 0 && slices.Contains(methods, lib.MethodICMP) {
+		sweeper, err := lib.NewICMPSweeper(privilegedICMP)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to open a shared ICMP socket, pinging each host separately: %v\n", err)
+		} else {
+			p.icmp = sweeper
+		}
+	}
+
+	return p, nil
+}
+
+func (p *sharedProbing) scanOptions(cmd *cobra.Command) lib.ScanOptions {
+	timeoutTCP, _ := cmd.Flags().GetInt("tcp-timeout")
+	timeoutUDP, _ := cmd.Flags().GetInt("udp-timeout")
+	portConcurrency, _ := cmd.Flags().GetInt("port-concurrency")
+
+	return lib.ScanOptions{
+		TimeoutTCPMillis: timeoutTCP,
+		TimeoutUDPMillis: timeoutUDP,
+		Concurrency:      portConcurrency,
+		Budget:           p.budget,
+		Pacer:            p.pacer,
+		RTT:              p.rtt,
+		SYN:              p.syn,
+	}
+}
+
+func (p *sharedProbing) Close() {
+	if p.syn != nil {
+		p.syn.Close()
+	}
+	if p.icmp != nil {
+		p.icmp.Close()
+	}
+}
+
+// openStateFile opens --state-file, returning nil when no state file is used.
+func openStateFile(cmd *cobra.Command) (*lib.StateFile, error) {
+	stateFile, _ := cmd.Flags().GetString("state-file")
+	resume, _ := cmd.Flags().GetBool("resume")
+	if stateFile == "" {
+		if resume {
+			return nil, fmt.Errorf("--resume requires --state-file")
+		}
+		return nil, nil
+	}
+
+	state, err := lib.OpenStateFile(stateFile, resume)
+	if err != nil {
+		return nil, err
+	}
+	if resume {
+		fmt.Fprintf(os.Stderr, "Resuming with %d hosts already finished\n", len(state.Results()))
+	}
+	return state, nil
+}
+
+func resumedResults(state *lib.StateFile) []lib.HostResult {
+	if state == nil {
+		return nil
+	}
+	return state.Results()
+}
+
+func recordState(state *lib.StateFile, result lib.HostResult) {
+	if state == nil {
+		return
+	}
+	if err := state.Record(result); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+// interruptContext is cancelled by the first Ctrl-C so copper can stop and
+// still write out what it found. A second Ctrl-C exits immediately.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
+
+func reportInterrupted(ctx context.Context, state *lib.StateFile) {
+	if ctx.Err() == nil {
+		return
+	}
+
+	// the progress bar was left unfinished on the current line
+	fmt.Fprint(os.Stderr, "\nInterrupted, results are partial.")
+	if state != nil {
+		fmt.Fprint(os.Stderr, " Run again with --resume to finish the scan.")
+	}
+	fmt.Fprintln(os.Stderr)
+}
+
+// newResultWriter returns the writer for --output-format, or nil for the plain
+// text output.
+func newResultWriter(cmd *cobra.Command) (lib.ResultWriter, error) {
+	format, _ := cmd.Flags().GetString("output-format")
+	verboseMode, _ := cmd.Flags().GetBool("verbose")
+	if format == "text" {
+		return nil, nil
+	}
+	return lib.NewResultWriter(os.Stdout, format, verboseMode)
+}
+
+func writeResult(writer lib.ResultWriter, result lib.HostResult) {
+	if err := writer.WriteResult(result); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+func closeResultWriter(writer lib.ResultWriter) {
+	if err := writer.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+func newResolver(cmd *cobra.Command) *lib.Resolver {
+	resolverAddr, _ := cmd.Flags().GetString("resolver")
+	resolveConcurrency, _ := cmd.Flags().GetInt("resolve-concurrency")
+	resolveTimeout, _ := cmd.Flags().GetInt("resolve-timeout")
+
+	return lib.NewResolver(resolverAddr, resolveConcurrency, resolveTimeout)
+}
+
+func newProgressBar(scope *lib.Scope) *progressbar.ProgressBar {
+	// hosts found by sweeping prefixes can't be counted up front
+	barSize := int64(scope.Count())
+	if len(scope.Prefixes) > 0 {
+		barSize = -1
+	}
+	return progressbar.Default(barSize)
+}
+
+// advance moves the bar on by one result. Hostnames with several records
+// produce more results than the scope counted, so the bar grows to fit them.
+func advance(bar *progressbar.ProgressBar) {
+	if state := bar.State(); state.Max >= 0 && state.CurrentNum >= state.Max {
+		bar.ChangeMax64(state.Max + 1)
+	}
+	bar.Add(1)
+}
+
+func isUnresolved(result lib.HostResult) bool {
+	return result.Reason == lib.ReasonNoSuchHost || result.Reason == lib.ReasonResolveFailed
+}
+
+// reportUnresolved lists the scope hostnames that did not resolve, which were
+// never probed and so are not counted as checked.
+func reportUnresolved(unresolved []lib.HostResult) {
+	if len(unresolved) == 0 {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "%d hostnames did not resolve:\n", len(unresolved))
+	for _, result := range unresolved {
+		fmt.Fprintf(os.Stderr, "  %s\t%s\n", result.Hostname, result.Reason)
+	}
+}
+
+func describeResult(result lib.HostResult) string {
+	method := result.Method
+	if result.Port > 0 {
+		method = fmt.Sprintf("%s/%d", method, result.Port)
+	}
+	if result.MAC != "" {
+		method = fmt.Sprintf("%s/%s", method, result.MAC)
+		if result.Vendor != "" {
+			method = fmt.Sprintf("%s (%s)", method, result.Vendor)
+		}
+	}
+	host := result.Host
+	if result.Hostname != "" {
+		host = fmt.Sprintf("%s (%s)", host, result.Hostname)
+	}
+	return fmt.Sprintf("%s\t%s\t%s\t%s", host, method, result.Reason, result.Latency)
+}
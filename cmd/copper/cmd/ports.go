@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/analog-substance/copper/pkg/lib"
+	"github.com/spf13/cobra"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+)
+
+var portsCmd = &cobra.Command{
+	Use:   "ports",
+	Short: "Discover active hosts and scan them for open ports",
+	Long: `Runs host discovery over the scope, then scans every active host for open
+ports as soon as it is found. Hosts that are not active are never port scanned.
+
+Ports use nmap syntax, for example -p 1-1024,8080,U:53,161. Ports are TCP
+unless prefixed with U:, which applies to the ports that follow it. Service
+names such as http, the most popular ports with top:N, and exclusions with a
+leading minus are also accepted: -p top:1000,U:top:20,-25
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		verboseMode, _ := cmd.Flags().GetBool("verbose")
+		portSpec, _ := cmd.Flags().GetString("ports")
+		topPorts, _ := cmd.Flags().GetInt("top-ports")
+		scanHosts, _ := cmd.Flags().GetInt("scan-hosts")
+
+		spec := lib.PortSpec{TCP: lib.GetTopPopularPorts("tcp", topPorts)}
+		if portSpec != "" {
+			var err error
+			spec, err = lib.ParsePortSpec(portSpec)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+		}
+
+		run, err := newDiscoveryRun(cmd)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+		if run == nil {
+			return
+		}
+		defer run.close()
+
+		scanOptions := run.probing.scanOptions(cmd)
+
+		var (
+			wg        sync.WaitGroup
+			mutex     sync.Mutex
+			scanSlots = make(chan struct{}, max(scanHosts, 1))
+			openPorts = 0
+			active    = 0
+		)
+
+		// output must be called with mutex held, which serialises every write
+		// to stdout and the writer between the scans and the main goroutine
+		output := func(result lib.HostResult) {
+			openPorts += len(result.Ports)
+			if run.writer != nil {
+				writeResult(run.writer, result)
+				return
+			}
+			for _, port := range result.Ports {
+				fmt.Printf("%s/%s\t%s\t%s\n", net.JoinHostPort(result.Host, strconv.Itoa(port.Port)), port.Protocol, port.State, port.Service)
+			}
+		}
+
+		// active hosts are port scanned unless they were already scanned by
+		// the run being resumed, and only recorded once their scan finishes
+		for result, resumed := range run.results() {
+			if !resumed && !result.Active {
+				recordState(run.state, result)
+			}
+			if run.writer != nil && !result.Active {
+				mutex.Lock()
+				writeResult(run.writer, result)
+				mutex.Unlock()
+			}
+
+			if !run.tally(result) || !result.Active {
+				continue
+			}
+
+			active++
+			if verboseMode && run.writer == nil {
+				mutex.Lock()
+				fmt.Println(describeResult(result))
+				mutex.Unlock()
+			}
+
+			if resumed {
+				mutex.Lock()
+				output(result)
+				mutex.Unlock()
+				continue
+			}
+
+			wg.Add(1)
+			scanSlots <- struct{}{}
+			go func() {
+				defer wg.Done()
+				defer func() { <-scanSlots }()
+
+				for _, port := range lib.ScanPorts(run.ctx, result.Host, spec, scanOptions) {
+					if port.State == lib.PortOpen {
+						result.Ports = append(result.Ports, port)
+					}
+				}
+
+				// a scan cut short by Ctrl-C is left for --resume to redo
+				if run.ctx.Err() == nil {
+					recordState(run.state, result)
+				}
+
+				mutex.Lock()
+				defer mutex.Unlock()
+				output(result)
+			}()
+		}
+		wg.Wait()
+
+		run.finish(fmt.Sprintf("%d are active with %d open ports", active, openPorts))
+	},
+}
+
+func init() {
+	addDiscoveryFlags(portsCmd)
+	addScanFlags(portsCmd)
+	portsCmd.Flags().Bool("privilegedICMP", false, "Use this if using sudo rather than `sudo sysctl -w net.ipv4.ping_group_range=\"0 2147483647\"`")
+	portsCmd.Flags().StringP("ports", "p", "", "Ports to scan, e.g. 1-1024,ssh,top:200,-25,U:53. Overrides --top-ports")
+	portsCmd.Flags().Int("top-ports", 1000, "Number of the most popular TCP ports to scan")
+	portsCmd.Flags().Int("scan-hosts", 10, "Number of active hosts to port scan at once")
+	rootCmd.AddCommand(portsCmd)
+}
@@ -1,15 +1,12 @@
 package cmd
 
 import (
-	"bufio"
 	"fmt"
 	"github.com/analog-substance/copper/pkg/lib"
 	"github.com/spf13/cobra"
-	"io"
-	"log"
+	"net"
 	"os"
-	"strings"
-	"time"
+	"strconv"
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -25,79 +22,64 @@ If a method succeeds, the host is marked as active and not touched again.
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	Run: func(cmd *cobra.Command, args []string) {
-		timeoutICMP, _ := cmd.Flags().GetInt("icmp-timeout")
-		timeoutTCP, _ := cmd.Flags().GetInt("tcp-timeout")
 		tcpPortCount, _ := cmd.Flags().GetInt("tcp-ports")
-		attempts, _ := cmd.Flags().GetInt("attempts")
-		scopeFile, _ := cmd.Flags().GetString("file")
 		verboseMode, _ := cmd.Flags().GetBool("verbose")
-		workerCount, _ := cmd.Flags().GetInt("workers")
-		privilegedICMP, _ := cmd.Flags().GetBool("privileged")
 		host, _ := cmd.Flags().GetString("host")
 
 		if host != "" {
-			ports := lib.GetOpenPortsOnHost(host, lib.GetTopPopularPorts("tcp", tcpPortCount), timeoutTCP)
+			probing, err := newSharedProbing(cmd, false)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+			defer probing.Close()
+
+			ctx, stop := interruptContext()
+			defer stop()
+
+			ports := lib.GetOpenPortsOnHost(ctx, host, lib.GetTopPopularPorts("tcp", tcpPortCount), probing.scanOptions(cmd))
 			for _, port := range ports {
-				fmt.Printf("%s:%d\n", host, port)
+				fmt.Println(net.JoinHostPort(host, strconv.Itoa(port)))
 			}
 			return
 		}
 
-		start := time.Now()
+		run, err := newDiscoveryRun(cmd)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+		if run == nil {
+			return
+		}
+		defer run.close()
 
-		hosts := []string{}
-		var scopeReader io.Reader
-		var err error
-		if scopeFile == "-" {
-			scopeReader = os.Stdin
-		} else {
-			if _, err = os.Stat(scopeFile); err == nil {
-				scopeReader, err = os.Open(scopeFile)
-				if err != nil {
-					fmt.Println(err)
-				}
-			} else {
-				fmt.Printf("unable to open scope file: %s\n", scopeFile)
-				return
+		activeHosts := []string{}
+		for result, resumed := range run.results() {
+			if !resumed {
+				recordState(run.state, result)
+			}
+			if run.writer != nil {
+				writeResult(run.writer, result)
 			}
-		}
 
-		scanner := bufio.NewScanner(scopeReader)
-		for scanner.Scan() {
-			input := scanner.Text()
-			if strings.Contains(input, "*") {
+			if !run.tally(result) || !result.Active {
 				continue
 			}
 
-			if strings.Contains(input, "/") {
-				hosts = append(hosts, lib.ExpandCIDR(input)...)
-			} else {
-				hosts = append(hosts, input)
+			activeHosts = append(activeHosts, result.Host)
+			if verboseMode && run.writer == nil {
+				fmt.Println(describeResult(result))
 			}
 		}
 
-		if err := scanner.Err(); err != nil {
-			log.Println(err)
-		}
-
-		//if scopeFile != "-" {
-		//	scopeReader.Close()
-		//}
-
-		if workerCount == 0 {
-			workerCount = len(hosts)
-		}
-
-		activeHosts := lib.DiscoverHosts(hosts, verboseMode, attempts, timeoutICMP, timeoutTCP, tcpPortCount, workerCount, privilegedICMP)
-
-		if !verboseMode {
+		if run.writer == nil && !verboseMode {
 			for _, host := range activeHosts {
 				fmt.Println(host)
 			}
 		}
 
-		duration := time.Since(start)
-		fmt.Printf("Checked %d hosts, %d are active. Took %s\n", len(hosts), len(activeHosts), duration)
+		run.finish(fmt.Sprintf("%d are active", len(activeHosts)))
 	},
 }
 
@@ -109,13 +91,8 @@ func Execute() {
 }
 
 func init() {
-	rootCmd.Flags().BoolP("verbose", "v", false, "Print active hosts as they are found")
+	addDiscoveryFlags(rootCmd)
+	addScanFlags(rootCmd)
 	rootCmd.Flags().BoolP("privilegedICMP", "p", false, "Use this if using sudo rather than `sudo sysctl -w net.ipv4.ping_group_range=\"0 2147483647\"`")
-	rootCmd.Flags().IntP("icmp-timeout", "i", 500, "ICMP timeout in milliseconds. To disable ICMP checks set to 0.")
-	rootCmd.Flags().IntP("tcp-timeout", "t", 500, "TCP timeout in milliseconds.  To disable TCP checks set to 0.")
-	rootCmd.Flags().IntP("tcp-ports", "T", 100, "Number of TCP ports to check")
-	rootCmd.Flags().IntP("workers", "w", 0, "Worker count. defaults to the number of hosts")
-	rootCmd.Flags().IntP("attempts", "a", 1, "Number of attempts per host")
-	rootCmd.Flags().StringP("file", "f", "scope.txt", "File with scope to check")
 	rootCmd.Flags().String("host", "", "Used to test port scanning a host")
 }
@@ -0,0 +1,78 @@
+package lib
+
+import (
+	"context"
+	"net"
+	"net/netip"
+)
+
+type arpProber struct {
+	timeoutMillis int
+}
+
+func (p *arpProber) Name() string {
+	return MethodARP
+}
+
+func (p *arpProber) Cost() int {
+	return 0
+}
+
+func (p *arpProber) Probe(ctx context.Context, target Target) (Evidence, error) {
+	return HostRespondsToARP(ctx, target.Host, p.timeoutMillis)
+}
+
+// onLinkInterface returns the interface and source address to use when addr is
+// on a subnet directly attached to this machine.
+func onLinkInterface(addr netip.Addr) (*net.Interface, netip.Addr, bool) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, netip.Addr{}, false
+	}
+
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+
+		for _, a := range addrs {
+			ipNet, ok := a.(*net.IPNet)
+			if !ok {
+				continue
+			}
+
+			src, ok := netip.AddrFromSlice(ipNet.IP)
+			if !ok {
+				continue
+			}
+			src = src.Unmap()
+			ones, _ := ipNet.Mask.Size()
+			prefix := netip.PrefixFrom(src, ones).Masked()
+
+			if src.Is4() == addr.Is4() && prefix.Contains(addr) && src != addr {
+				return &iface, src, true
+			}
+		}
+	}
+
+	return nil, netip.Addr{}, false
+}
+
+// resolveTargetAddr turns a target host into an address, resolving it when it
+// is a hostname.
+func resolveTargetAddr(ctx context.Context, host string, network string) (netip.Addr, error) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return addr.Unmap(), nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, network, host)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return addrs[0].Unmap(), nil
+}
@@ -0,0 +1,124 @@
+//go:build linux
+
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const etherTypeARP = 0x0806
+
+// HostRespondsToARP sends an ARP request for host when it is on a directly
+// attached IPv4 subnet. Hosts that are not on-link produce empty evidence so
+// the next prober can be tried.
+func HostRespondsToARP(ctx context.Context, host string, timeoutMillisARP int) (Evidence, error) {
+	addr, err := resolveTargetAddr(ctx, host, "ip4")
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) {
+			return Evidence{Reason: ReasonNoSuchHost}, nil
+		}
+		return Evidence{}, err
+	}
+
+	if !addr.Is4() {
+		return Evidence{}, nil
+	}
+
+	iface, src, ok := onLinkInterface(addr)
+	if !ok || len(iface.HardwareAddr) != 6 {
+		return Evidence{}, nil
+	}
+
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_CLOEXEC, int(htons(etherTypeARP)))
+	if err != nil {
+		return Evidence{}, err
+	}
+	defer unix.Close(fd)
+
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(etherTypeARP), Ifindex: iface.Index}); err != nil {
+		return Evidence{}, err
+	}
+
+	broadcast := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	request := arpRequestFrame(iface.HardwareAddr, src.AsSlice(), addr.AsSlice())
+
+	start := time.Now()
+	dest := &unix.SockaddrLinklayer{Protocol: htons(etherTypeARP), Ifindex: iface.Index, Halen: 6}
+	copy(dest.Addr[:], broadcast)
+	if err := unix.Sendto(fd, request, 0, dest); err != nil {
+		return Evidence{}, err
+	}
+
+	deadline := start.Add(time.Duration(timeoutMillisARP) * time.Millisecond)
+	buf := make([]byte, 1500)
+	for ctx.Err() == nil && time.Now().Before(deadline) {
+		wait := min(time.Until(deadline), 100*time.Millisecond)
+		tv := unix.NsecToTimeval(max(wait, time.Millisecond).Nanoseconds())
+		if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+			return Evidence{}, err
+		}
+
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+				continue
+			}
+			return Evidence{}, err
+		}
+
+		if mac, ok := parseARPReply(buf[:n], addr.AsSlice()); ok {
+			return Evidence{
+				Alive:   true,
+				Reason:  ReasonARPResponse,
+				Latency: time.Since(start),
+				MAC:     mac.String(),
+				Vendor:  MACVendor(mac),
+			}, nil
+		}
+	}
+
+	return Evidence{Reason: ReasonNoResponse}, nil
+}
+
+func arpRequestFrame(srcMAC net.HardwareAddr, srcIP, targetIP []byte) []byte {
+	frame := make([]byte, 0, 42)
+	frame = append(frame, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+	frame = append(frame, srcMAC...)
+	frame = binary.BigEndian.AppendUint16(frame, etherTypeARP)
+
+	frame = binary.BigEndian.AppendUint16(frame, 1)      // hardware type: ethernet
+	frame = binary.BigEndian.AppendUint16(frame, 0x0800) // protocol type: IPv4
+	frame = append(frame, 6, 4)
+	frame = binary.BigEndian.AppendUint16(frame, 1) // operation: request
+	frame = append(frame, srcMAC...)
+	frame = append(frame, srcIP...)
+	frame = append(frame, 0, 0, 0, 0, 0, 0)
+	frame = append(frame, targetIP...)
+
+	return frame
+}
+
+func parseARPReply(frame []byte, targetIP []byte) (net.HardwareAddr, bool) {
+	if len(frame) < 42 || binary.BigEndian.Uint16(frame[12:14]) != etherTypeARP {
+		return nil, false
+	}
+
+	arp := frame[14:]
+	if binary.BigEndian.Uint16(arp[6:8]) != 2 || !bytes.Equal(arp[14:18], targetIP) {
+		return nil, false
+	}
+
+	return net.HardwareAddr(bytes.Clone(arp[8:14])), true
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package lib
+
+import (
+	"context"
+	"errors"
+)
+
+func HostRespondsToARP(ctx context.Context, host string, timeoutMillisARP int) (Evidence, error) {
+	return Evidence{}, errors.New("arp discovery is only supported on linux")
+}
@@ -0,0 +1,38 @@
+package lib
+
+import "context"
+
+// ConnBudget caps the number of connections in flight across every scan that
+// shares it. A nil budget is unlimited.
+type ConnBudget struct {
+	slots chan struct{}
+}
+
+func NewConnBudget(size int) *ConnBudget {
+	if size <= 0 {
+		return nil
+	}
+	return &ConnBudget{make(chan struct{}, size)}
+}
+
+// Acquire blocks until a connection slot is free or ctx is done. Every
+// successful Acquire must be followed by a Release.
+func (b *ConnBudget) Acquire(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *ConnBudget) Release() {
+	if b == nil {
+		return
+	}
+	<-b.slots
+}
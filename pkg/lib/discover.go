@@ -4,239 +4,258 @@ import (
 	"context"
 	"fmt"
 	probing "github.com/prometheus-community/pro-bing"
-	"github.com/schollz/progressbar/v3"
-	"log"
 	"net"
 	"net/netip"
-	"strings"
+	"sync"
 	"time"
 )
 
-func HostRespondsToICMP(host string, timeoutMillisICMP int, privilegedICMP bool) bool {
+func HostRespondsToICMP(ctx context.Context, host string, timeoutMillisICMP int, privilegedICMP bool) Evidence {
 	pinger, err := probing.NewPinger(host)
 	if err != nil {
-		return false
+		return Evidence{Reason: ReasonNoSuchHost}
 	}
 	pinger.Count = 1
 	pinger.SetPrivileged(privilegedICMP)
 	pinger.Timeout = time.Duration(timeoutMillisICMP) * time.Millisecond
-	err = pinger.Run()
+	err = pinger.RunWithContext(ctx)
 	if err != nil {
-		if !strings.Contains(err.Error(), "sendto") {
-			log.Println("error running ping", host, err)
-		}
-		return false
+		return Evidence{Reason: ReasonError}
 	}
 	stats := pinger.Statistics()
 
 	if stats.PacketsRecv > 0 {
-		return true
+		return Evidence{Alive: true, Reason: ReasonEchoReply, Latency: stats.AvgRtt}
 	}
-	return false
+	return Evidence{Reason: ReasonNoResponse}
 }
 
-func HostHasOpenPort(host string, ports []int, timeoutTCPMillis int) bool {
+// HostHasOpenPort dials ports on host one at a time until one proves the host
+// alive. Cancelling ctx aborts the dial in flight.
+func HostHasOpenPort(ctx context.Context, host string, ports []int, timeoutTCPMillis int) Evidence {
 	for _, port := range ports {
-		err := makeTCPConnection(host, timeoutTCPMillis, port)
-
-		if err != nil {
-			if strings.HasSuffix(err.Error(), "connect: connection refused") {
-				return true
-			}
-
-			if strings.HasSuffix(err.Error(), "connect: no route to host") {
-				return false
-			}
-
-			if strings.HasSuffix(err.Error(), "no such host") {
-				return false
-			}
-
-			if strings.HasSuffix(err.Error(), "network is unreachable") {
-				return false
-			}
-
-			if strings.HasSuffix(err.Error(), "i/o timeout") {
-				continue
-			}
+		if ctx.Err() != nil {
+			break
+		}
 
-			if strings.HasSuffix(err.Error(), "operation was canceled") {
-				continue
-			}
+		start := time.Now()
+		err := makeTCPConnection(ctx, host, timeoutTCPMillis, port)
+		latency := time.Since(start)
 
-			println(err.Error())
-			continue
+		state, reason := classifyDialError(err)
+		switch state {
+		case PortOpen, PortClosed:
+			return Evidence{Alive: true, Port: port, Reason: reason, Latency: latency}
+		case PortUnreachable:
+			return Evidence{Port: port, Reason: reason}
 		}
-
-		return true
 	}
-	return false
+	return Evidence{Reason: ReasonNoResponse}
 }
 
-func GetOpenPortsOnHost(host string, ports []int, timeoutTCPMillis int) []int {
-	portResults := map[int]bool{}
-
-	for _, port := range ports {
-		err := makeTCPConnection(host, timeoutTCPMillis, port)
-
-		if err != nil {
-			if strings.HasSuffix(err.Error(), "connect: connection refused") {
-				portResults[port] = false
-				continue
-			}
-
-			if strings.HasSuffix(err.Error(), "connect: no route to host") {
-				portResults[port] = false
-				continue
-			}
-
-			if strings.HasSuffix(err.Error(), "no such host") {
-				portResults[port] = false
-				continue
-			}
-
-			if strings.HasSuffix(err.Error(), "network is unreachable") {
-				portResults[port] = false
-				continue
-			}
-
-			if strings.HasSuffix(err.Error(), "i/o timeout") {
-				portResults[port] = false
-				continue
-			}
-
-			if strings.HasSuffix(err.Error(), "operation was canceled") {
-				portResults[port] = false
-				continue
-			}
-
-			continue
-		}
+func makeTCPConnection(ctx context.Context, host string, timeoutTCPMillis int, port int) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeoutTCPMillis)*time.Millisecond)
+	defer cancel()
 
-		portResults[port] = true
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(host, fmt.Sprint(port)))
+	if err == nil {
+		conn.Close()
 	}
+	return err
+}
 
-	openPorts := []int{}
-	for port, open := range portResults {
-		if open {
-			openPorts = append(openPorts, port)
-		}
-	}
+// DefaultWorkers is how many hosts are checked at once when Options.Workers is
+// not set, however large the scope.
+var DefaultWorkers = 256
+
+// Options configures a Discoverer. Probers are tried in order for each host
+// until one proves it alive; when empty the default probers are used.
+// Sweepers discover hosts in prefixes that are too large to enumerate.
+// Resolver expands hostnames in the scope, using the system resolver when nil.
+// Skip reports targets that must not be checked, such as hosts finished by an
+// earlier run that is being resumed. SweepError is called with the error of
+// every sweeper that fails to sweep some of the prefixes. Prefixes containing
+// excluded hosts are only swept with SweepExcluded set.
+type Options struct {
+	Workers       int
+	Attempts      int
+	Probers       []Prober
+	Sweepers      []Sweeper
+	Resolver      *Resolver
+	Skip          func(target Target) bool
+	SweepError    func(sweeper string, err error)
+	SweepExcluded bool
+}
 
-	return openPorts
+// Discoverer checks hosts for liveness and streams results as they complete.
+type Discoverer struct {
+	opts Options
 }
 
-func makeTCPConnection(host string, timeoutTCPMillis int, port int) error {
-	d := net.Dialer{
-		Timeout:  time.Duration(timeoutTCPMillis) * time.Millisecond,
-		Deadline: time.Now().Add(time.Duration(timeoutTCPMillis) * time.Millisecond),
+func NewDiscoverer(opts Options) *Discoverer {
+	if opts.Attempts < 1 {
+		opts.Attempts = 1
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeoutTCPMillis)*time.Millisecond)
-	//defer cancel()
-	go func() {
-		time.Sleep(time.Duration(timeoutTCPMillis) * time.Millisecond)
-		cancel()
-	}()
-	conn, err := d.DialContext(ctx, "tcp", fmt.Sprintf("%s:%d", host, port))
-	if err == nil {
-		conn.Close()
+
+	if len(opts.Probers) == 0 {
+		opts.Probers, _ = NewProbers(nil, DefaultProberConfig)
 	}
-	return err
-}
 
-func checkHost(host string, c chan hostStatus, ports []int, timeoutMillisICMP, timeoutTCPMillis int, privilegedICMP bool) {
-	if timeoutMillisICMP > 0 && HostRespondsToICMP(host, timeoutMillisICMP, privilegedICMP) {
-		c <- hostStatus{host, "ICMP", true}
-		return
+	if len(opts.Sweepers) == 0 {
+		opts.Sweepers = []Sweeper{NewNeighborSweeper(DefaultProberConfig)}
 	}
 
-	if timeoutTCPMillis > 0 && HostHasOpenPort(host, ports, timeoutTCPMillis) {
-		c <- hostStatus{host, "TCP Ports", true}
-		return
+	if opts.Resolver == nil {
+		opts.Resolver = NewResolver("", 0, 0)
 	}
 
-	c <- hostStatus{host, "", false}
+	return &Discoverer{opts: opts}
 }
 
-func worker(hosts chan string, res chan hostStatus, ports []int, timeoutMillisICMP, timeoutTCPMillis int, privilegedICMP bool) {
-
-	for host := range hosts {
-		checkHost(host, res, ports, timeoutMillisICMP, timeoutTCPMillis, privilegedICMP)
+// Discover checks every host in scope and sends one HostResult per host on
+// the returned channel, followed by any hosts the sweepers find in the scope
+// prefixes. Hostnames are resolved first and every address they resolve to is
+// checked and reported with Hostname set, while names that fail to resolve are
+// reported once with ReasonNoSuchHost or ReasonResolveFailed. The channel is
+// closed once all hosts have been checked or ctx is cancelled; hosts
+// interrupted by cancellation are not reported.
+func (d *Discoverer) Discover(ctx context.Context, scope *Scope) <-chan HostResult {
+	results := make(chan HostResult)
+	jobs := make(chan Target)
+
+	workerCount := d.opts.Workers
+	if workerCount <= 0 {
+		workerCount = DefaultWorkers
+	}
+	workerCount = min(workerCount, scope.Count())
+
+	var wg sync.WaitGroup
+	for i := 0; i < workerCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.worker(ctx, jobs, results)
+		}()
 	}
-}
 
-func DiscoverHosts(hosts []string, verboseMode bool, attempts, timeoutMillisICMP, timeoutTCPMillis, portCheckCount, workerCount int, privilegedICMP bool) []string {
-	bar := progressbar.Default(int64(len(hosts)))
-	workers := make(chan string, workerCount)
-	c := make(chan hostStatus)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+		for target, err := range d.opts.Resolver.Resolve(ctx, scope.Targets()) {
+			var result HostResult
+			switch {
+			case d.skip(target):
+				continue
+			case err != nil:
+				result = HostResult{Host: target.Host, Hostname: target.Host, Reason: resolveReason(err), Time: time.Now()}
+			case target.Name != "" && scope.IsExcluded(target):
+				// names can only be excluded by address once they are resolved
+				result = HostResult{Host: target.Host, Hostname: target.Name, Reason: ReasonExcluded, Time: time.Now()}
+			default:
+				select {
+				case jobs <- target:
+					continue
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case results <- result:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
 
-	ports := GetTopPopularPorts("tcp", portCheckCount)
+	if prefixes := scope.SweepPrefixes(d.opts.SweepExcluded); len(prefixes) > 0 {
+		swept := make(chan HostResult)
+		var sweepers sync.WaitGroup
+		for _, sweeper := range d.opts.Sweepers {
+			sweepers.Add(1)
+			go func() {
+				defer sweepers.Done()
+				err := sweeper.Sweep(ctx, prefixes, swept)
+				if err != nil && ctx.Err() == nil && d.opts.SweepError != nil {
+					d.opts.SweepError(sweeper.Name(), err)
+				}
+			}()
+		}
 
-	for i := 0; i < cap(workers); i++ {
-		go worker(workers, c, ports, timeoutMillisICMP, timeoutTCPMillis, privilegedICMP)
+		go func() {
+			sweepers.Wait()
+			close(swept)
+		}()
+
+		// sweepers can't avoid hearing from excluded hosts, so drop them here
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for result := range swept {
+				addr, _ := netip.ParseAddr(result.Host)
+				if target := (Target{Host: result.Host, Addr: addr}); scope.IsExcluded(target) || d.skip(target) {
+					continue
+				}
+
+				select {
+				case results <- result:
+				case <-ctx.Done():
+				}
+			}
+		}()
 	}
 
 	go func() {
-		for _, host := range hosts {
-			workers <- host
-		}
+		wg.Wait()
+		close(results)
 	}()
 
-	result := make([]hostStatus, len(hosts))
-	activeHosts := []string{}
-	inactiveHosts := []string{}
+	return results
+}
 
-	for i, _ := range result {
-		result[i] = <-c
-		bar.Add(1)
-		if result[i].active {
-			activeHosts = append(activeHosts, result[i].host)
+func (d *Discoverer) skip(target Target) bool {
+	return d.opts.Skip != nil && d.opts.Skip(target)
+}
 
-			if verboseMode {
-				fmt.Printf("%s\t%s\n", result[i].host, result[i].method)
-			}
-			//fmt.Println(result[i].host, "is up.")
-		} else {
-			// pin in this, we need to talk about how to handle async shit as a library
-			inactiveHosts = append(inactiveHosts, result[i].host)
+func (d *Discoverer) worker(ctx context.Context, jobs <-chan Target, results chan<- HostResult) {
+	for target := range jobs {
+		result := d.checkHost(ctx, target)
+		if ctx.Err() != nil {
+			return
 		}
-	}
-
-	close(workers)
-	close(c)
 
-	attempts--
-	if attempts > 0 && len(inactiveHosts) > 0 {
-		if verboseMode {
-			fmt.Printf("Performing additional attempt on %d hosts.\n", len(inactiveHosts))
+		select {
+		case results <- result:
+		case <-ctx.Done():
+			return
 		}
-		reallyActive := DiscoverHosts(inactiveHosts, verboseMode, attempts, timeoutMillisICMP, timeoutTCPMillis, portCheckCount, workerCount, privilegedICMP)
-		activeHosts = append(activeHosts, reallyActive...)
 	}
-
-	return activeHosts
 }
 
-type hostStatus struct {
-	host   string
-	method string
-	active bool
-}
+func (d *Discoverer) checkHost(ctx context.Context, target Target) HostResult {
+	result := HostResult{Host: target.Host, Hostname: target.Name, Reason: ReasonNoResponse}
 
-func ExpandCIDR(cidr string) []string {
-	prefix, err := netip.ParsePrefix(cidr)
-	if err != nil {
-		panic(err)
-	}
+	for attempt := 1; attempt <= d.opts.Attempts; attempt++ {
+		result.Attempt = attempt
 
-	var ips []string
-	for addr := prefix.Addr(); prefix.Contains(addr); addr = addr.Next() {
-		ips = append(ips, addr.String())
-	}
+		for _, prober := range d.opts.Probers {
+			if ctx.Err() != nil {
+				break
+			}
+
+			evidence, err := prober.Probe(ctx, target)
+			if err != nil {
+				evidence = Evidence{Reason: ReasonError}
+			}
 
-	if len(ips) < 2 {
-		return ips
+			if result.record(prober.Name(), evidence) {
+				return result
+			}
+		}
 	}
 
-	return ips[1 : len(ips)-1]
+	result.Time = time.Now()
+	return result
 }
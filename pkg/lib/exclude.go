@@ -0,0 +1,249 @@
+package lib
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// addrRange is an inclusive range of addresses from the same family.
+type addrRange struct {
+	from netip.Addr
+	to   netip.Addr
+}
+
+func (r addrRange) contains(addr netip.Addr) bool {
+	addr = addr.WithZone("")
+	return addr.BitLen() == r.from.BitLen() && addr.Compare(r.from) >= 0 && addr.Compare(r.to) <= 0
+}
+
+func prefixRange(prefix netip.Prefix) addrRange {
+	prefix = prefix.Masked()
+	last := prefix.Addr().AsSlice()
+	for bit := prefix.Bits(); bit < len(last)*8; bit++ {
+		last[bit/8] |= 0x80 >> (bit % 8)
+	}
+	to, _ := netip.AddrFromSlice(last)
+	return addrRange{prefix.Addr(), to}
+}
+
+// parseAddrRange parses a dash range, either between two full addresses as in
+// 10.0.0.1-10.0.0.50 or with only the last octet after the dash as in
+// 10.0.0.1-50.
+func parseAddrRange(entry string) (addrRange, error) {
+	fromText, toText, ok := strings.Cut(entry, "-")
+	if !ok {
+		return addrRange{}, fmt.Errorf("not an address range: %s", entry)
+	}
+
+	from, err := netip.ParseAddr(strings.TrimSpace(fromText))
+	if err != nil {
+		return addrRange{}, fmt.Errorf("invalid address range: %s", entry)
+	}
+
+	toText = strings.TrimSpace(toText)
+	to, err := netip.ParseAddr(toText)
+	if err != nil && from.Is4() {
+		var octet uint8
+		if _, scanErr := fmt.Sscanf(toText, "%d", &octet); scanErr == nil && fmt.Sprint(octet) == toText {
+			a := from.As4()
+			a[3] = octet
+			to, err = netip.AddrFrom4(a), nil
+		}
+	}
+	if err != nil {
+		return addrRange{}, fmt.Errorf("invalid address range: %s", entry)
+	}
+
+	if from.BitLen() != to.BitLen() || to.Less(from) {
+		return addrRange{}, fmt.Errorf("invalid address range: %s", entry)
+	}
+
+	return addrRange{from, to}, nil
+}
+
+// addrOffset returns how far addr is past base, which must not be after addr.
+// Both must be from the same family. Offsets that don't fit in 64 bits, such
+// as across IPv6 /64s, are capped at math.MaxUint64.
+func addrOffset(base, addr netip.Addr) uint64 {
+	b, a := base.As16(), addr.As16()
+	low, borrow := bits.Sub64(binary.BigEndian.Uint64(a[8:]), binary.BigEndian.Uint64(b[8:]), 0)
+	if high, _ := bits.Sub64(binary.BigEndian.Uint64(a[:8]), binary.BigEndian.Uint64(b[:8]), borrow); high != 0 {
+		return math.MaxUint64
+	}
+	return low
+}
+
+// Exclude removes an IP, CIDR, dash range or hostname from the scope.
+// Hostnames are also looked up with resolver so that their addresses are
+// excluded when they turn up in a CIDR or under a different name, and a
+// hostname that fails to resolve is an error since its addresses could
+// otherwise still be probed. Prefixes that are excluded entirely are dropped.
+func (s *Scope) Exclude(ctx context.Context, resolver *Resolver, entry string) error {
+	entry = strings.TrimSpace(entry)
+	if entry == "" {
+		return nil
+	}
+
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return err
+		}
+		s.excludeRange(prefixRange(prefix))
+		return nil
+	}
+
+	if addr, err := netip.ParseAddr(entry); err == nil {
+		addr = addr.WithZone("")
+		s.excludeRange(addrRange{addr, addr})
+		return nil
+	}
+
+	if r, err := parseAddrRange(entry); err == nil {
+		s.excludeRange(r)
+		return nil
+	}
+
+	if s.excludedNames == nil {
+		s.excludedNames = map[string]bool{}
+	}
+	s.excludedNames[strings.ToLower(strings.TrimSuffix(entry, "."))] = true
+
+	addrs, err := resolver.lookup(ctx, entry)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		s.excludeRange(addrRange{addr, addr})
+	}
+
+	return nil
+}
+
+func (s *Scope) excludeRange(r addrRange) {
+	s.excludedRanges = append(s.excludedRanges, r)
+	s.Prefixes = slices.DeleteFunc(s.Prefixes, s.prefixExcluded)
+}
+
+// prefixExcluded reports whether every address in prefix is excluded, walking
+// the exclusions in order and looking for a gap between them.
+func (s *Scope) prefixExcluded(prefix netip.Prefix) bool {
+	r := prefixRange(prefix)
+	ranges := slices.Clone(s.excludedRanges)
+	slices.SortFunc(ranges, func(a, b addrRange) int {
+		return a.from.Compare(b.from)
+	})
+
+	next := r.from
+	for _, excluded := range ranges {
+		if excluded.from.BitLen() != next.BitLen() || excluded.to.Less(next) {
+			continue
+		}
+		if next.Less(excluded.from) {
+			return false
+		}
+		if !excluded.to.Less(r.to) {
+			return true
+		}
+		next = excluded.to.Next()
+	}
+	return false
+}
+
+// prefixHasExclusions reports whether any address in prefix is excluded.
+func (s *Scope) prefixHasExclusions(prefix netip.Prefix) bool {
+	r := prefixRange(prefix)
+	for _, excluded := range s.excludedRanges {
+		if excluded.from.BitLen() == r.from.BitLen() && !excluded.to.Less(r.from) && !r.to.Less(excluded.from) {
+			return true
+		}
+	}
+	return false
+}
+
+// SweepPrefixes returns the Prefixes that are safe to sweep. Sweeps reach
+// every host on the link, so prefixes containing an exclusion are left out
+// unless withExclusions is set, in which case the excluded hosts that answer
+// are only dropped from the results.
+func (s *Scope) SweepPrefixes(withExclusions bool) []netip.Prefix {
+	if withExclusions {
+		return s.Prefixes
+	}
+	return slices.DeleteFunc(slices.Clone(s.Prefixes), s.prefixHasExclusions)
+}
+
+// IsExcluded reports whether target matches an exclusion by name or address.
+// Resolved targets are also matched by the name they were resolved from.
+func (s *Scope) IsExcluded(target Target) bool {
+	for _, name := range []string{target.Host, target.Name} {
+		if name != "" && s.excludedNames[strings.ToLower(strings.TrimSuffix(name, "."))] {
+			return true
+		}
+	}
+
+	return target.Addr.IsValid() && s.addrExcluded(target.Addr)
+}
+
+func (s *Scope) addrExcluded(addr netip.Addr) bool {
+	for _, r := range s.excludedRanges {
+		if r.contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasExclusions reports whether anything has been excluded from the scope.
+func (s *Scope) HasExclusions() bool {
+	return len(s.excludedRanges) > 0 || len(s.excludedNames) > 0
+}
+
+// ExcludedCount returns how many hosts in the scope were removed by
+// exclusions.
+func (s *Scope) ExcludedCount() int {
+	return s.count(false) - s.Count()
+}
+
+// excludedInRange returns how many addresses between from and to inclusive
+// are excluded.
+func (s *Scope) excludedInRange(from, to netip.Addr) int {
+	overlaps := []addrRange{}
+	for _, r := range s.excludedRanges {
+		if r.from.BitLen() != from.BitLen() || r.to.Less(from) || to.Less(r.from) {
+			continue
+		}
+		overlap := r
+		if overlap.from.Less(from) {
+			overlap.from = from
+		}
+		if to.Less(overlap.to) {
+			overlap.to = to
+		}
+		overlaps = append(overlaps, overlap)
+	}
+
+	slices.SortFunc(overlaps, func(a, b addrRange) int {
+		return a.from.Compare(b.from)
+	})
+
+	excluded := 0
+	var covered netip.Addr
+	for _, r := range overlaps {
+		if covered.IsValid() && !covered.Less(r.from) {
+			if !covered.Less(r.to) {
+				continue
+			}
+			r.from = covered.Next()
+		}
+		excluded += int(addrOffset(r.from, r.to)) + 1
+		covered = r.to
+	}
+
+	return excluded
+}
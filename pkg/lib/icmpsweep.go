@@ -0,0 +1,191 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// ICMPSweeper pings any number of hosts over one ICMP socket per address
+// family, matching echo replies to requests by address and sequence number.
+// It replaces a socket and pinger per host, which runs out of file
+// descriptors with thousands of workers. Unprivileged sockets need
+// net.ipv4.ping_group_range to include the user.
+type ICMPSweeper struct {
+	privileged bool
+	id         int
+	conn4      net.PacketConn
+	conn6      net.PacketConn
+
+	mutex   sync.Mutex
+	pending map[icmpKey]chan time.Time
+	seq     uint32
+
+	receivers sync.WaitGroup
+}
+
+type icmpKey struct {
+	addr netip.Addr
+	seq  uint16
+}
+
+// icmpReadBuffer is sized so a burst of replies from thousands of workers is
+// not dropped before it can be read.
+const icmpReadBuffer = 4 << 20
+
+// NewICMPSweeper opens the shared sockets. IPv6 is optional, IPv4 is not.
+func NewICMPSweeper(privileged bool) (*ICMPSweeper, error) {
+	conn4, err := listenICMP(privileged, "ip4:icmp", "udp4", "0.0.0.0")
+	if err != nil {
+		return nil, err
+	}
+	conn6, _ := listenICMP(privileged, "ip6:ipv6-icmp", "udp6", "::")
+
+	s := &ICMPSweeper{
+		privileged: privileged,
+		id:         rand.IntN(0xffff),
+		conn4:      conn4,
+		conn6:      conn6,
+		pending:    map[icmpKey]chan time.Time{},
+		seq:        rand.Uint32(),
+	}
+
+	s.receivers.Add(1)
+	go s.receive(conn4, protocolICMP)
+	if conn6 != nil {
+		s.receivers.Add(1)
+		go s.receive(conn6, protocolICMPv6)
+	}
+
+	return s, nil
+}
+
+// Ping sends a single echo request to addr and waits for the reply, for at
+// most timeoutMillis.
+func (s *ICMPSweeper) Ping(ctx context.Context, addr netip.Addr, timeoutMillis int) (Evidence, error) {
+	addr = addr.Unmap()
+	conn, network, request := s.conn4, "udp4", icmp.Type(ipv4.ICMPTypeEcho)
+	if addr.Is6() {
+		conn, network, request = s.conn6, "udp6", ipv6.ICMPTypeEchoRequest
+	}
+	if conn == nil {
+		return Evidence{}, errors.New("no ICMPv6 socket")
+	}
+	if s.privileged {
+		network = "ip"
+	}
+
+	key := icmpKey{addr.WithZone(""), uint16(atomic.AddUint32(&s.seq, 1))}
+	reply := make(chan time.Time, 1)
+	s.mutex.Lock()
+	s.pending[key] = reply
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.pending, key)
+		s.mutex.Unlock()
+	}()
+
+	// unprivileged sockets have their ID replaced by the kernel, which also
+	// filters replies for us
+	msg, err := (&icmp.Message{
+		Type: request,
+		Body: &icmp.Echo{ID: s.id, Seq: int(key.seq), Data: []byte("copper")},
+	}).Marshal(nil)
+	if err != nil {
+		return Evidence{}, err
+	}
+
+	start := time.Now()
+	if _, err := conn.WriteTo(msg, icmpAddr(network, addr)); err != nil {
+		return Evidence{}, err
+	}
+
+	timer := time.NewTimer(time.Duration(timeoutMillis) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case at := <-reply:
+		return Evidence{Alive: true, Reason: ReasonEchoReply, Latency: at.Sub(start)}, nil
+	case <-timer.C:
+		return Evidence{Reason: ReasonNoResponse}, nil
+	case <-ctx.Done():
+		return Evidence{}, ctx.Err()
+	}
+}
+
+// listenICMP opens a raw socket when privileged, or an unprivileged datagram
+// socket otherwise. Only raw sockets get a larger read buffer, the datagram
+// ones can't be reached through icmp.PacketConn.
+func listenICMP(privileged bool, raw, datagram, address string) (net.PacketConn, error) {
+	if !privileged {
+		conn, err := icmp.ListenPacket(datagram, address)
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	}
+
+	conn, err := net.ListenPacket(raw, address)
+	if err != nil {
+		return nil, err
+	}
+	conn.(*net.IPConn).SetReadBuffer(icmpReadBuffer)
+	return conn, nil
+}
+
+func (s *ICMPSweeper) receive(conn net.PacketConn, protocol int) {
+	defer s.receivers.Done()
+
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			continue
+		}
+		at := time.Now()
+
+		msg, err := icmp.ParseMessage(protocol, buf[:n])
+		if err != nil || (msg.Type != ipv4.ICMPTypeEchoReply && msg.Type != ipv6.ICMPTypeEchoReply) {
+			continue
+		}
+
+		echo, ok := msg.Body.(*icmp.Echo)
+		addr, valid := peerAddr(peer)
+		if !ok || !valid || (s.privileged && echo.ID != s.id) {
+			continue
+		}
+
+		s.mutex.Lock()
+		reply := s.pending[icmpKey{addr.WithZone(""), uint16(echo.Seq)}]
+		s.mutex.Unlock()
+		if reply == nil {
+			continue
+		}
+
+		select {
+		case reply <- at:
+		default:
+		}
+	}
+}
+
+func (s *ICMPSweeper) Close() error {
+	err := s.conn4.Close()
+	if s.conn6 != nil {
+		s.conn6.Close()
+	}
+	s.receivers.Wait()
+	return err
+}
@@ -0,0 +1,261 @@
+package lib
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+)
+
+const (
+	InputAuto    = "auto"
+	InputList    = "list"
+	InputNmap    = "nmap"
+	InputMasscan = "masscan"
+	InputCopper  = "copper"
+)
+
+// InputFormats are the scope formats ImportScope understands.
+var InputFormats = []string{InputAuto, InputList, InputNmap, InputMasscan, InputCopper}
+
+// ImportScope reads a scope in format, which may be a plain scope list, nmap
+// XML, masscan JSON or copper's own json or jsonl output. InputAuto detects the
+// format from how the input starts. Hosts imported from scan results carry the
+// TCP ports those scans found open, which the tcp prober tries first.
+func ImportScope(r io.Reader, format string) (*Scope, error) {
+	reader := bufio.NewReader(r)
+	if format == InputAuto {
+		format = detectInputFormat(reader)
+	}
+
+	switch format {
+	case InputList:
+		return ParseScope(reader)
+	case InputNmap:
+		return importNmapXML(reader)
+	case InputMasscan, InputCopper:
+		return importJSON(reader)
+	}
+	return nil, fmt.Errorf("unknown input format %q, available: %s", format, strings.Join(InputFormats, ","))
+}
+
+func detectInputFormat(reader *bufio.Reader) string {
+	switch firstByte(reader) {
+	case '<':
+		return InputNmap
+	case '{':
+		// masscan and copper output are both read by importJSON
+		return InputCopper
+	case '[':
+		// a JSON array, or a list starting with [2001:db8::1]:443
+		if next := byteAfterBracket(reader); next == '{' || next == ']' {
+			return InputCopper
+		}
+	}
+	return InputList
+}
+
+// byteAfterBracket returns the first byte after the leading [ that isn't
+// whitespace, without consuming anything, or 0 if there is none within the
+// reader's buffer.
+func byteAfterBracket(reader *bufio.Reader) byte {
+	for n := 2; n <= reader.Size(); n++ {
+		b, err := reader.Peek(n)
+		if err != nil {
+			return 0
+		}
+		if c := b[n-1]; !strings.ContainsRune(" \t\r\n", rune(c)) {
+			return c
+		}
+	}
+	return 0
+}
+
+// firstByte skips leading whitespace and returns the next byte without
+// consuming it, or 0 at the end of the input.
+func firstByte(reader *bufio.Reader) byte {
+	for {
+		b, err := reader.Peek(1)
+		if err != nil {
+			return 0
+		}
+		if !strings.ContainsRune(" \t\r\n", rune(b[0])) {
+			return b[0]
+		}
+		reader.ReadByte()
+	}
+}
+
+// importedScope collects hosts from scan results, merging the open ports of
+// hosts that appear more than once.
+type importedScope struct {
+	scope *Scope
+	hosts map[string]int
+	errs  []error
+}
+
+func newImportedScope() *importedScope {
+	return &importedScope{scope: &Scope{}, hosts: map[string]int{}}
+}
+
+func (s *importedScope) add(host string, name string, openPorts []int) {
+	if i, ok := s.hosts[host]; ok {
+		entry := &s.scope.entries[i]
+		entry.knownPorts = uniquePorts(entry.knownPorts, openPorts)
+		if entry.name == "" {
+			entry.name = name
+		}
+		return
+	}
+
+	if _, err := netip.ParseAddr(host); err != nil && !isHostname(host) {
+		s.errs = append(s.errs, fmt.Errorf("%q: not an IP or hostname", host))
+		return
+	}
+
+	s.hosts[host] = len(s.scope.entries)
+	s.scope.entries = append(s.scope.entries, scopeEntry{host: host, name: name, knownPorts: uniquePorts(openPorts)})
+}
+
+func (s *importedScope) result() (*Scope, error) {
+	return s.scope, errors.Join(s.errs...)
+}
+
+// importNmapXML reads the hosts of an nmaprun document one at a time, so large
+// scan results are never held in memory as a whole.
+func importNmapXML(r io.Reader) (*Scope, error) {
+	imported := newImportedScope()
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid nmap XML: %w", err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "host" {
+			continue
+		}
+
+		var host nmapHost
+		if err := decoder.DecodeElement(&host, &start); err != nil {
+			return nil, fmt.Errorf("invalid nmap XML: %w", err)
+		}
+
+		addr := ""
+		for _, address := range host.Addresses {
+			if address.AddrType == "ipv4" || address.AddrType == "ipv6" {
+				addr = address.Addr
+				break
+			}
+		}
+		if addr == "" {
+			continue
+		}
+
+		name := ""
+		if host.Hostnames != nil && len(host.Hostnames.Hostnames) > 0 {
+			name = host.Hostnames.Hostnames[0].Name
+		}
+
+		openPorts := []int{}
+		if host.Ports != nil {
+			for _, port := range host.Ports.Ports {
+				if port.Protocol == "tcp" && port.State.State == "open" {
+					openPorts = append(openPorts, port.PortID)
+				}
+			}
+		}
+
+		imported.add(addr, name, openPorts)
+	}
+
+	return imported.result()
+}
+
+// jsonRecord covers the fields copper needs from both masscan's -oJ output,
+// which uses ip, proto and status, and copper's own HostResult.
+type jsonRecord struct {
+	Host     string `json:"host"`
+	IP       string `json:"ip"`
+	Hostname string `json:"hostname"`
+	Method   string `json:"method"`
+	Port     int    `json:"port"`
+	Reason   string `json:"reason"`
+	Ports    []struct {
+		Port     int    `json:"port"`
+		Protocol string `json:"protocol"`
+		Proto    string `json:"proto"`
+		State    string `json:"state"`
+		Status   string `json:"status"`
+	} `json:"ports"`
+}
+
+// importJSON reads a JSON array or a stream of JSON objects, which covers
+// masscan -oJ as well as copper's json and jsonl output.
+func importJSON(reader *bufio.Reader) (*Scope, error) {
+	imported := newImportedScope()
+	decoder := json.NewDecoder(reader)
+
+	isArray := firstByte(reader) == '['
+	if isArray {
+		decoder.Token()
+	}
+
+	for !isArray || decoder.More() {
+		var record jsonRecord
+		err := decoder.Decode(&record)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		imported.addRecord(record)
+	}
+
+	return imported.result()
+}
+
+func (s *importedScope) addRecord(record jsonRecord) {
+	host := record.Host
+	if host == "" {
+		host = record.IP
+	}
+	if host == "" {
+		// masscan ends its output with a {"finished": 1} record
+		return
+	}
+	switch record.Reason {
+	case ReasonExcluded, ReasonNoSuchHost, ReasonResolveFailed:
+		// verbose copper output lists these, but they were never probed and
+		// excluded hosts must not become targets again
+		return
+	}
+
+	openPorts := []int{}
+	if record.Method == MethodTCP && record.Reason == ReasonSynAck && record.Port > 0 {
+		openPorts = append(openPorts, record.Port)
+	}
+	for _, port := range record.Ports {
+		protocol := cmp.Or(port.Protocol, port.Proto)
+		state := cmp.Or(port.State, port.Status)
+		if protocol == "tcp" && state == "open" {
+			openPorts = append(openPorts, port.Port)
+		}
+	}
+
+	name := record.Hostname
+	if name == host {
+		name = ""
+	}
+	s.add(host, name, openPorts)
+}
@@ -0,0 +1,63 @@
+package lib
+
+import (
+	"bufio"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDetectInputFormat(t *testing.T) {
+	tests := []struct {
+		input  string
+		format string
+	}{
+		{"10.0.0.1\n", InputList},
+		{"# scope\n10.0.0.1\n", InputList},
+		{"[2001:db8::1]:443\n", InputList},
+		{"  [2001:db8::1]\n", InputList},
+		{"[", InputList},
+		{"<?xml version=\"1.0\"?>\n<nmaprun>", InputNmap},
+		{"[\n{\"ip\": \"10.0.0.1\"}\n]", InputCopper},
+		{"[ ]", InputCopper},
+		{"[]", InputCopper},
+		{"{\"host\": \"10.0.0.1\"}\n", InputCopper},
+		{"", InputList},
+	}
+
+	for _, test := range tests {
+		if format := detectInputFormat(bufio.NewReader(strings.NewReader(test.input))); format != test.format {
+			t.Errorf("detectInputFormat(%q) = %s, want %s", test.input, format, test.format)
+		}
+	}
+}
+
+func TestImportScopeAuto(t *testing.T) {
+	tests := []struct {
+		input string
+		hosts []string
+	}{
+		{"[2001:db8::1]:443\n10.0.0.1\n", []string{"2001:db8::1", "10.0.0.1"}},
+		{"[\n{\"ip\": \"10.0.0.1\", \"ports\": [{\"port\": 22, \"status\": \"open\"}]}\n]", []string{"10.0.0.1"}},
+		{"{\"host\": \"10.0.0.1\", \"reason\": \"syn-ack\"}\n{\"host\": \"10.0.0.2\", \"reason\": \"no-response\"}\n" +
+			"{\"host\": \"10.0.0.9\", \"hostname\": \"do-not-touch.example.com\", \"reason\": \"excluded\"}\n" +
+			"{\"host\": \"missing.example.com\", \"hostname\": \"missing.example.com\", \"reason\": \"no-such-host\"}\n" +
+			"{\"host\": \"broken.example.com\", \"hostname\": \"broken.example.com\", \"reason\": \"resolve-failed\"}\n", []string{"10.0.0.1", "10.0.0.2"}},
+	}
+
+	for _, test := range tests {
+		scope, err := ImportScope(strings.NewReader(test.input), InputAuto)
+		if err != nil {
+			t.Errorf("ImportScope(%q) returned error: %v", test.input, err)
+			continue
+		}
+
+		hosts := []string{}
+		for target := range scope.Targets() {
+			hosts = append(hosts, target.Host)
+		}
+		if !slices.Equal(hosts, test.hosts) {
+			t.Errorf("ImportScope(%q) targets = %v, want %v", test.input, hosts, test.hosts)
+		}
+	}
+}
@@ -0,0 +1,433 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+const protocolICMPv6 = 58
+
+var allNodes = netip.MustParseAddr("ff02::1")
+
+// NeighborSweeper finds IPv6 hosts by sending an ICMPv6 echo request to the
+// all-nodes multicast group on every link that overlaps the scope. When raw
+// sockets are available each responder is also sent a Neighbor Solicitation
+// so its link-layer address can be recorded.
+type NeighborSweeper struct {
+	timeoutMillis int
+	privileged    bool
+}
+
+func NewNeighborSweeper(cfg ProberConfig) *NeighborSweeper {
+	return &NeighborSweeper{cfg.TimeoutSweepMillis, cfg.PrivilegedICMP}
+}
+
+func (s *NeighborSweeper) Name() string {
+	return MethodNDP
+}
+
+func (s *NeighborSweeper) Sweep(ctx context.Context, prefixes []netip.Prefix, results chan<- HostResult) error {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return err
+	}
+
+	var (
+		wg    sync.WaitGroup
+		mutex sync.Mutex
+		seen  = map[netip.Addr]bool{}
+		swept = map[netip.Prefix]bool{}
+		errs  []error
+	)
+
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+
+		for _, src := range sweepSources(&iface, prefixes, swept) {
+			wg.Add(1)
+			go func(iface net.Interface, src netip.Addr) {
+				defer wg.Done()
+
+				found, err := s.sweepLink(ctx, &iface, src)
+				if err != nil {
+					mutex.Lock()
+					errs = append(errs, err)
+					mutex.Unlock()
+					return
+				}
+
+				for _, result := range found {
+					addr := netip.MustParseAddr(result.Host).WithZone("")
+					if !prefixesContain(prefixes, addr) {
+						continue
+					}
+
+					mutex.Lock()
+					duplicate := seen[addr]
+					seen[addr] = true
+					mutex.Unlock()
+
+					if duplicate {
+						continue
+					}
+
+					select {
+					case results <- result:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}(iface, src)
+		}
+	}
+
+	wg.Wait()
+
+	// only on-link prefixes can be swept, others would quietly find nothing
+	for _, prefix := range prefixes {
+		if !swept[prefix] {
+			errs = append(errs, fmt.Errorf("unable to sweep %s, it is not on a local link", prefix))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sweepSources returns the addresses on iface whose subnet overlaps one of the
+// prefixes. Replies to a multicast echo come back from the responder's address
+// that best matches our source, so sweeping from each overlapping subnet finds
+// both link-local and global responders. The overlapping prefixes are marked
+// in swept.
+func sweepSources(iface *net.Interface, prefixes []netip.Prefix, swept map[netip.Prefix]bool) []netip.Addr {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil
+	}
+
+	sources := []netip.Addr{}
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+
+		src, ok := netip.AddrFromSlice(ipNet.IP)
+		if !ok || src.Is4() || src.Is4In6() {
+			continue
+		}
+		ones, _ := ipNet.Mask.Size()
+		subnet := netip.PrefixFrom(src, ones).Masked()
+
+		overlaps := false
+		for _, prefix := range prefixes {
+			if prefix.Overlaps(subnet) {
+				swept[prefix] = true
+				overlaps = true
+			}
+		}
+		if overlaps {
+			sources = append(sources, src)
+		}
+	}
+
+	return sources
+}
+
+func prefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *NeighborSweeper) sweepLink(ctx context.Context, iface *net.Interface, src netip.Addr) ([]HostResult, error) {
+	network := "udp6"
+	if s.privileged {
+		network = "ip6:ipv6-icmp"
+	}
+
+	if src.IsLinkLocalUnicast() {
+		src = src.WithZone(iface.Name)
+	}
+
+	conn, err := icmp.ListenPacket(network, src.String())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	pc := conn.IPv6PacketConn()
+	pc.SetMulticastLoopback(false)
+	pc.SetMulticastInterface(iface)
+	pc.SetMulticastHopLimit(255)
+	pc.SetHopLimit(255)
+
+	id := os.Getpid() & 0xffff
+	echo, err := (&icmp.Message{
+		Type: ipv6.ICMPTypeEchoRequest,
+		Body: &icmp.Echo{ID: id, Seq: 1, Data: []byte("copper")},
+	}).Marshal(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	if _, err := conn.WriteTo(echo, icmpAddr(network, allNodes.WithZone(iface.Name))); err != nil {
+		return nil, err
+	}
+
+	found := map[netip.Addr]*HostResult{}
+	order := []netip.Addr{}
+	deadline := start.Add(time.Duration(s.timeoutMillis) * time.Millisecond)
+	buf := make([]byte, 1500)
+
+	for ctx.Err() == nil && time.Now().Before(deadline) {
+		conn.SetReadDeadline(pollDeadline(deadline))
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			continue
+		}
+
+		addr, ok := peerAddr(peer)
+		if !ok || addr.WithZone("") == src.WithZone("") {
+			continue
+		}
+
+		msg, err := icmp.ParseMessage(protocolICMPv6, buf[:n])
+		if err != nil {
+			continue
+		}
+
+		switch msg.Type {
+		case ipv6.ICMPTypeEchoReply:
+			if _, ok := found[addr]; ok {
+				continue
+			}
+			found[addr] = &HostResult{
+				Host:    addr.String(),
+				Active:  true,
+				Method:  MethodNDP,
+				Reason:  ReasonEchoReply,
+				Latency: time.Since(start),
+				Attempt: 1,
+				Time:    time.Now(),
+			}
+			order = append(order, addr)
+
+			if s.privileged {
+				conn.WriteTo(neighborSolicitation(addr, iface.HardwareAddr), icmpAddr(network, solicitedNode(addr).WithZone(iface.Name)))
+			}
+		case ipv6.ICMPTypeNeighborAdvertisement:
+			target, mac, ok := parseNeighborAdvertisement(msg)
+			if !ok {
+				continue
+			}
+			if target.IsLinkLocalUnicast() {
+				target = target.WithZone(iface.Name)
+			}
+			if result, ok := found[target]; ok && mac != nil {
+				result.MAC = mac.String()
+				result.Vendor = MACVendor(mac)
+			}
+		}
+	}
+
+	results := []HostResult{}
+	for _, addr := range order {
+		results = append(results, *found[addr])
+	}
+	return results, nil
+}
+
+type ndpProber struct {
+	timeoutMillis int
+}
+
+func (p *ndpProber) Name() string {
+	return MethodNDP
+}
+
+func (p *ndpProber) Cost() int {
+	return 0
+}
+
+func (p *ndpProber) Probe(ctx context.Context, target Target) (Evidence, error) {
+	return HostRespondsToNDP(ctx, target.Host, p.timeoutMillis)
+}
+
+// HostRespondsToNDP sends a Neighbor Solicitation for host when it is on a
+// directly attached IPv6 subnet. It is the IPv6 counterpart of
+// HostRespondsToARP and needs a raw socket.
+func HostRespondsToNDP(ctx context.Context, host string, timeoutMillisNDP int) (Evidence, error) {
+	addr, err := resolveTargetAddr(ctx, host, "ip6")
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) {
+			return Evidence{Reason: ReasonNoSuchHost}, nil
+		}
+		return Evidence{}, err
+	}
+
+	if !addr.Is6() {
+		return Evidence{}, nil
+	}
+
+	iface, src, ok := onLinkInterface(addr.WithZone(""))
+	if !ok {
+		return Evidence{}, nil
+	}
+
+	if src.IsLinkLocalUnicast() {
+		src = src.WithZone(iface.Name)
+	}
+
+	conn, err := icmp.ListenPacket("ip6:ipv6-icmp", src.String())
+	if err != nil {
+		return Evidence{}, err
+	}
+	defer conn.Close()
+
+	pc := conn.IPv6PacketConn()
+	pc.SetMulticastInterface(iface)
+	pc.SetMulticastHopLimit(255)
+	pc.SetHopLimit(255)
+
+	start := time.Now()
+	dest := icmpAddr("ip6:ipv6-icmp", solicitedNode(addr).WithZone(iface.Name))
+	if _, err := conn.WriteTo(neighborSolicitation(addr, iface.HardwareAddr), dest); err != nil {
+		return Evidence{}, err
+	}
+
+	deadline := start.Add(time.Duration(timeoutMillisNDP) * time.Millisecond)
+	buf := make([]byte, 1500)
+	for ctx.Err() == nil && time.Now().Before(deadline) {
+		conn.SetReadDeadline(pollDeadline(deadline))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			continue
+		}
+
+		msg, err := icmp.ParseMessage(protocolICMPv6, buf[:n])
+		if err != nil || msg.Type != ipv6.ICMPTypeNeighborAdvertisement {
+			continue
+		}
+
+		target, mac, ok := parseNeighborAdvertisement(msg)
+		if !ok || target != addr.WithZone("") {
+			continue
+		}
+
+		evidence := Evidence{Alive: true, Reason: ReasonNDResponse, Latency: time.Since(start)}
+		if mac != nil {
+			evidence.MAC = mac.String()
+			evidence.Vendor = MACVendor(mac)
+		}
+		return evidence, nil
+	}
+
+	return Evidence{Reason: ReasonNoResponse}, nil
+}
+
+// solicitedNode returns the solicited-node multicast group for addr.
+func solicitedNode(addr netip.Addr) netip.Addr {
+	a := addr.As16()
+	group := netip.MustParseAddr("ff02::1:ff00:0").As16()
+	copy(group[13:], a[13:])
+	return netip.AddrFrom16(group)
+}
+
+func neighborSolicitation(target netip.Addr, mac net.HardwareAddr) []byte {
+	t := target.As16()
+	body := append([]byte{0, 0, 0, 0}, t[:]...)
+	if len(mac) == 6 {
+		// source link-layer address option
+		body = append(body, 1, 1)
+		body = append(body, mac...)
+	}
+
+	b, _ := (&icmp.Message{
+		Type: ipv6.ICMPTypeNeighborSolicitation,
+		Body: &icmp.RawBody{Data: body},
+	}).Marshal(nil)
+	return b
+}
+
+func parseNeighborAdvertisement(msg *icmp.Message) (netip.Addr, net.HardwareAddr, bool) {
+	body, ok := msg.Body.(*icmp.RawBody)
+	if !ok || len(body.Data) < 20 {
+		return netip.Addr{}, nil, false
+	}
+
+	target := netip.AddrFrom16([16]byte(body.Data[4:20]))
+
+	options := body.Data[20:]
+	for len(options) >= 8 {
+		length := int(options[1]) * 8
+		if length == 0 || length > len(options) {
+			break
+		}
+		// target link-layer address option
+		if options[0] == 2 && length >= 8 {
+			return target, net.HardwareAddr(bytes.Clone(options[2:8])), true
+		}
+		options = options[length:]
+	}
+
+	return target, nil, true
+}
+
+func icmpAddr(network string, addr netip.Addr) net.Addr {
+	if network == "udp6" || network == "udp4" {
+		return &net.UDPAddr{IP: addr.AsSlice(), Zone: addr.Zone()}
+	}
+	return &net.IPAddr{IP: addr.AsSlice(), Zone: addr.Zone()}
+}
+
+func peerAddr(peer net.Addr) (netip.Addr, bool) {
+	var (
+		ip   net.IP
+		zone string
+	)
+
+	switch a := peer.(type) {
+	case *net.UDPAddr:
+		ip, zone = a.IP, a.Zone
+	case *net.IPAddr:
+		ip, zone = a.IP, a.Zone
+	default:
+		return netip.Addr{}, false
+	}
+
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return netip.Addr{}, false
+	}
+	addr = addr.Unmap()
+	if addr.Is6() && addr.IsLinkLocalUnicast() {
+		addr = addr.WithZone(zone)
+	}
+	return addr, true
+}
+
+// pollDeadline returns a read deadline no later than deadline and short enough
+// that a cancelled context is noticed promptly.
+func pollDeadline(deadline time.Time) time.Time {
+	poll := time.Now().Add(100 * time.Millisecond)
+	if poll.Before(deadline) {
+		return poll
+	}
+	return deadline
+}
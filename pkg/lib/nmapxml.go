@@ -0,0 +1,234 @@
+package lib
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+)
+
+// The nmap* types mirror the subset of the nmap.dtd elements copper fills in.
+
+type nmapHost struct {
+	XMLName   xml.Name       `xml:"host"`
+	StartTime int64          `xml:"starttime,attr,omitempty"`
+	EndTime   int64          `xml:"endtime,attr,omitempty"`
+	Status    nmapStatus     `xml:"status"`
+	Addresses []nmapAddress  `xml:"address"`
+	Hostnames *nmapHostnames `xml:"hostnames"`
+	Ports     *nmapPorts     `xml:"ports"`
+	Times     *nmapTimes     `xml:"times"`
+}
+
+type nmapStatus struct {
+	State     string `xml:"state,attr"`
+	Reason    string `xml:"reason,attr"`
+	ReasonTTL int    `xml:"reason_ttl,attr"`
+}
+
+type nmapAddress struct {
+	Addr     string `xml:"addr,attr"`
+	AddrType string `xml:"addrtype,attr"`
+	Vendor   string `xml:"vendor,attr,omitempty"`
+}
+
+type nmapHostnames struct {
+	Hostnames []nmapHostname `xml:"hostname"`
+}
+
+type nmapHostname struct {
+	Name string `xml:"name,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type nmapPorts struct {
+	Ports []nmapPort `xml:"port"`
+}
+
+type nmapPort struct {
+	Protocol string       `xml:"protocol,attr"`
+	PortID   int          `xml:"portid,attr"`
+	State    nmapStatus   `xml:"state"`
+	Service  *nmapService `xml:"service"`
+}
+
+type nmapService struct {
+	Name   string `xml:"name,attr"`
+	Method string `xml:"method,attr"`
+	Conf   int    `xml:"conf,attr"`
+}
+
+type nmapTimes struct {
+	SRTT   int64 `xml:"srtt,attr"`
+	RTTVar int64 `xml:"rttvar,attr"`
+	TO     int64 `xml:"to,attr"`
+}
+
+// xmlWriter streams an nmaprun document, writing each host as it arrives so
+// a partial document is still mostly usable if copper is killed. Nothing is
+// written until the first host or Close, so a run that stops before
+// discovery leaves no unfinished document behind.
+type xmlWriter struct {
+	w           io.Writer
+	includeDown bool
+	start       time.Time
+	started     bool
+	up          int
+	down        int
+}
+
+func newXMLWriter(w io.Writer, includeDown bool) *xmlWriter {
+	return &xmlWriter{w: w, includeDown: includeDown, start: time.Now()}
+}
+
+func (w *xmlWriter) writeHeader() error {
+	if w.started {
+		return nil
+	}
+	w.started = true
+
+	args := strings.Join(os.Args, " ")
+	_, err := fmt.Fprintf(w.w, "%s<!DOCTYPE nmaprun>\n<nmaprun scanner=\"copper\" args=\"%s\" start=\"%d\" startstr=\"%s\" version=\"1.0\" xmloutputversion=\"1.05\">\n<verbose level=\"0\"/>\n<debugging level=\"0\"/>\n",
+		xml.Header, xmlEscape(args), w.start.Unix(), w.start.Format(time.ANSIC))
+	return err
+}
+
+func (w *xmlWriter) WriteResult(result HostResult) error {
+	addr, err := netip.ParseAddr(result.Host)
+	if err != nil || result.Reason == ReasonExcluded {
+		// nmap never lists hosts it didn't scan
+		return nil
+	}
+
+	if result.Active {
+		w.up++
+	} else {
+		w.down++
+		if !w.includeDown {
+			return nil
+		}
+	}
+
+	b, err := xml.MarshalIndent(newNmapHost(result, addr), "", "")
+	if err != nil {
+		return err
+	}
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w.w, "%s\n", b)
+	return err
+}
+
+func (w *xmlWriter) Close() error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+
+	end := time.Now()
+	elapsed := end.Sub(w.start).Seconds()
+	summary := fmt.Sprintf("copper done at %s; %d IP addresses (%d hosts up) scanned in %.2f seconds",
+		end.Format(time.ANSIC), w.up+w.down, w.up, elapsed)
+
+	_, err := fmt.Fprintf(w.w, "<runstats><finished time=\"%d\" timestr=\"%s\" summary=\"%s\" elapsed=\"%.2f\" exit=\"success\"/><hosts up=\"%d\" down=\"%d\" total=\"%d\"/>\n</runstats>\n</nmaprun>\n",
+		end.Unix(), end.Format(time.ANSIC), summary, elapsed, w.up, w.down, w.up+w.down)
+	return err
+}
+
+func newNmapHost(result HostResult, addr netip.Addr) nmapHost {
+	host := nmapHost{
+		Status: nmapStatus{State: "down", Reason: result.Reason},
+	}
+	if result.Active {
+		host.Status.State = "up"
+	}
+	if !result.Time.IsZero() {
+		host.StartTime = result.Time.Add(-result.Latency).Unix()
+		host.EndTime = result.Time.Unix()
+	}
+
+	addrType := "ipv4"
+	if addr.Is6() {
+		addrType = "ipv6"
+	}
+	host.Addresses = append(host.Addresses, nmapAddress{Addr: addr.String(), AddrType: addrType})
+	if result.MAC != "" {
+		host.Addresses = append(host.Addresses, nmapAddress{Addr: strings.ToUpper(result.MAC), AddrType: "mac", Vendor: result.Vendor})
+	}
+
+	if result.Hostname != "" {
+		host.Hostnames = &nmapHostnames{[]nmapHostname{{Name: result.Hostname, Type: "user"}}}
+	}
+
+	if ports := nmapPortsFor(result); len(ports) > 0 {
+		host.Ports = &nmapPorts{ports}
+	}
+
+	if result.Latency > 0 {
+		rtt := result.Latency.Microseconds()
+		host.Times = &nmapTimes{SRTT: rtt, RTTVar: rtt / 2, TO: max(rtt*4, 100000)}
+	}
+
+	return host
+}
+
+// nmapPortsFor lists the scanned ports of a host, plus the port that proved it
+// alive during discovery when the scan didn't cover it.
+func nmapPortsFor(result HostResult) []nmapPort {
+	ports := []nmapPort{}
+	seen := map[string]bool{}
+	for _, port := range result.Ports {
+		seen[fmt.Sprintf("%s/%d", port.Protocol, port.Port)] = true
+		ports = append(ports, newNmapPort(port.Protocol, port.Port, port.State.String(), portReason(port), port.Service))
+	}
+
+	if result.Port > 0 && (result.Method == MethodTCP || result.Method == MethodUDP) && !seen[fmt.Sprintf("%s/%d", result.Method, result.Port)] {
+		state := "open"
+		if result.Reason == ReasonReset || result.Reason == ReasonPortUnreachable {
+			state = "closed"
+		}
+		ports = append(ports, newNmapPort(result.Method, result.Port, state, result.Reason, ServiceName(result.Method, result.Port)))
+	}
+
+	return ports
+}
+
+func newNmapPort(protocol string, port int, state string, reason string, service string) nmapPort {
+	p := nmapPort{
+		Protocol: protocol,
+		PortID:   port,
+		State:    nmapStatus{State: state, Reason: reason},
+	}
+	if service != "" {
+		p.Service = &nmapService{Name: service, Method: "table", Conf: 3}
+	}
+	return p
+}
+
+// portReason recovers the reason nmap would give for a scanned port's state.
+func portReason(port PortResult) string {
+	switch port.State {
+	case PortOpen:
+		if port.Protocol == "udp" {
+			return ReasonUDPResponse
+		}
+		return ReasonSynAck
+	case PortClosed:
+		if port.Protocol == "udp" {
+			return ReasonPortUnreachable
+		}
+		return ReasonReset
+	case PortUnreachable:
+		return ReasonHostUnreachable
+	}
+	return ReasonNoResponse
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
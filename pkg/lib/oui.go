@@ -0,0 +1,76 @@
+package lib
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// commonOUIs is used when nmap's MAC prefix database is not installed.
+var commonOUIs = map[string]string{
+	"000C29": "VMware",
+	"005056": "VMware",
+	"000569": "VMware",
+	"080027": "Oracle VirtualBox virtual NIC",
+	"525400": "QEMU virtual NIC",
+	"00155D": "Microsoft",
+	"0003FF": "Microsoft",
+	"B827EB": "Raspberry Pi Foundation",
+	"DCA632": "Raspberry Pi Trading",
+	"E45F01": "Raspberry Pi Trading",
+	"00000C": "Cisco Systems",
+	"001B21": "Intel Corporate",
+	"3C5AB4": "Google",
+	"F4F5D8": "Google",
+	"001C42": "Parallels",
+	"0242AC": "Docker",
+}
+
+var (
+	ouiOnce   sync.Once
+	ouiVendor map[string]string
+)
+
+// MACVendor returns the vendor registered for the OUI of mac, or an empty
+// string when it is unknown.
+func MACVendor(mac net.HardwareAddr) string {
+	if len(mac) < 3 {
+		return ""
+	}
+
+	ouiOnce.Do(func() {
+		vendors, err := getNmapMACPrefixes()
+		if err != nil {
+			vendors = commonOUIs
+		}
+		ouiVendor = vendors
+	})
+
+	return ouiVendor[strings.ToUpper(strings.ReplaceAll(mac[:3].String(), ":", ""))]
+}
+
+func getNmapMACPrefixes() (map[string]string, error) {
+	prefixFile, err := os.Open("/usr/share/nmap/nmap-mac-prefixes")
+	if err != nil {
+		return nil, err
+	}
+	defer prefixFile.Close()
+
+	vendors := map[string]string{}
+	scanner := bufio.NewScanner(prefixFile)
+	for scanner.Scan() {
+		prefix, vendor, ok := strings.Cut(scanner.Text(), " ")
+		if !ok || strings.HasPrefix(prefix, "#") || len(prefix) != 6 {
+			continue
+		}
+		vendors[strings.ToUpper(prefix)] = vendor
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return vendors, nil
+}
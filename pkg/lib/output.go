@@ -0,0 +1,241 @@
+package lib
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FormatJSONL    = "jsonl"
+	FormatJSON     = "json"
+	FormatCSV      = "csv"
+	FormatGrepable = "grepable"
+	FormatXML      = "xml"
+)
+
+// OutputFormats are the machine readable formats NewResultWriter supports.
+var OutputFormats = []string{FormatJSONL, FormatJSON, FormatCSV, FormatGrepable, FormatXML}
+
+// ResultWriter streams host results in a machine readable format. Close must
+// be called once all results are written to finish the document.
+type ResultWriter interface {
+	WriteResult(result HostResult) error
+	Close() error
+}
+
+// NewResultWriter returns a ResultWriter for format that writes to w. Only
+// active hosts are written unless includeDown is set.
+func NewResultWriter(w io.Writer, format string, includeDown bool) (ResultWriter, error) {
+	switch format {
+	case FormatJSONL:
+		return &jsonlWriter{json.NewEncoder(w), includeDown}, nil
+	case FormatJSON:
+		return &jsonWriter{w: w, includeDown: includeDown}, nil
+	case FormatCSV:
+		return &csvWriter{w: csv.NewWriter(w), includeDown: includeDown}, nil
+	case FormatGrepable:
+		return newGrepableWriter(w, includeDown), nil
+	case FormatXML:
+		return newXMLWriter(w, includeDown), nil
+	}
+	return nil, fmt.Errorf("unknown output format %q, available: %s", format, strings.Join(OutputFormats, ","))
+}
+
+type jsonlWriter struct {
+	encoder     *json.Encoder
+	includeDown bool
+}
+
+func (w *jsonlWriter) WriteResult(result HostResult) error {
+	if !result.Active && !w.includeDown {
+		return nil
+	}
+	return w.encoder.Encode(result)
+}
+
+func (w *jsonlWriter) Close() error {
+	return nil
+}
+
+// jsonWriter writes a single array, streaming each element as it arrives.
+type jsonWriter struct {
+	w           io.Writer
+	includeDown bool
+	count       int
+}
+
+func (w *jsonWriter) WriteResult(result HostResult) error {
+	if !result.Active && !w.includeDown {
+		return nil
+	}
+
+	b, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	separator := ",\n  "
+	if w.count == 0 {
+		separator = "[\n  "
+	}
+	w.count++
+
+	_, err = fmt.Fprintf(w.w, "%s%s", separator, b)
+	return err
+}
+
+func (w *jsonWriter) Close() error {
+	if w.count == 0 {
+		_, err := fmt.Fprintln(w.w, "[]")
+		return err
+	}
+	_, err := fmt.Fprintln(w.w, "\n]")
+	return err
+}
+
+var csvHeader = []string{"time", "host", "hostname", "active", "method", "port", "reason", "latency_ms", "mac", "vendor", "attempt", "ports"}
+
+type csvWriter struct {
+	w           *csv.Writer
+	includeDown bool
+	started     bool
+}
+
+func (w *csvWriter) WriteResult(result HostResult) error {
+	if !result.Active && !w.includeDown {
+		return nil
+	}
+
+	if !w.started {
+		w.started = true
+		if err := w.w.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+
+	ports := []string{}
+	for _, port := range result.Ports {
+		ports = append(ports, fmt.Sprintf("%d/%s", port.Port, port.Protocol))
+	}
+
+	var port, latency string
+	if result.Port > 0 {
+		port = strconv.Itoa(result.Port)
+	}
+	if result.Latency > 0 {
+		latency = strconv.FormatFloat(float64(result.Latency)/float64(time.Millisecond), 'f', 3, 64)
+	}
+
+	err := w.w.Write([]string{
+		result.Time.Format(time.RFC3339Nano),
+		result.Host,
+		result.Hostname,
+		strconv.FormatBool(result.Active),
+		result.Method,
+		port,
+		result.Reason,
+		latency,
+		result.MAC,
+		result.Vendor,
+		strconv.Itoa(result.Attempt),
+		strings.Join(ports, ";"),
+	})
+	w.w.Flush()
+	if err != nil {
+		return err
+	}
+	return w.w.Error()
+}
+
+func (w *csvWriter) Close() error {
+	if !w.started {
+		w.w.Write(csvHeader)
+		w.w.Flush()
+	}
+	return w.w.Error()
+}
+
+// grepableWriter follows the layout of nmap -oG so existing tooling that greps
+// for "Status: Up" or "Ports:" keeps working. Like the XML writer, the header
+// is only written along with the first host or on Close.
+type grepableWriter struct {
+	w           io.Writer
+	includeDown bool
+	start       time.Time
+	started     bool
+	total       int
+	up          int
+}
+
+func newGrepableWriter(w io.Writer, includeDown bool) *grepableWriter {
+	return &grepableWriter{w: w, includeDown: includeDown, start: time.Now()}
+}
+
+func (w *grepableWriter) writeHeader() error {
+	if w.started {
+		return nil
+	}
+	w.started = true
+
+	_, err := fmt.Fprintf(w.w, "# copper scan initiated %s\n", w.start.Format(time.ANSIC))
+	return err
+}
+
+func (w *grepableWriter) WriteResult(result HostResult) error {
+	w.total++
+	if result.Active {
+		w.up++
+	} else if !w.includeDown {
+		return nil
+	}
+
+	status := "Down"
+	if result.Active {
+		status = "Up"
+	}
+
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+
+	host := fmt.Sprintf("Host: %s (%s)", result.Host, result.Hostname)
+	if _, err := fmt.Fprintf(w.w, "%s\tStatus: %s\tReason: %s\n", host, status, grepableReason(result)); err != nil {
+		return err
+	}
+
+	if len(result.Ports) == 0 {
+		return nil
+	}
+
+	ports := []string{}
+	for _, port := range result.Ports {
+		ports = append(ports, fmt.Sprintf("%d/%s/%s//%s///", port.Port, port.State, port.Protocol, port.Service))
+	}
+	_, err := fmt.Fprintf(w.w, "%s\tPorts: %s\n", host, strings.Join(ports, ", "))
+	return err
+}
+
+func grepableReason(result HostResult) string {
+	if result.Method == "" {
+		return result.Reason
+	}
+	if result.Port > 0 {
+		return fmt.Sprintf("%s %s/%d", result.Reason, result.Method, result.Port)
+	}
+	return fmt.Sprintf("%s %s", result.Reason, result.Method)
+}
+
+func (w *grepableWriter) Close() error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w.w, "# copper done at %s -- %d IP addresses (%d hosts up) scanned in %.2f seconds\n",
+		time.Now().Format(time.ANSIC), w.total, w.up, time.Since(w.start).Seconds())
+	return err
+}
@@ -0,0 +1,37 @@
+package lib
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCSVWriterHeaderOnly(t *testing.T) {
+	var buf bytes.Buffer
+	writer, err := NewResultWriter(&buf, FormatCSV, false)
+	if err != nil {
+		t.Fatalf("NewResultWriter returned error: %v", err)
+	}
+	if err := writer.WriteResult(HostResult{Host: "10.0.0.1"}); err != nil {
+		t.Fatalf("WriteResult returned error: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+
+	want := "time,host,hostname,active,method,port,reason,latency_ms,mac,vendor,attempt,ports\n"
+	if buf.String() != want {
+		t.Errorf("output = %q, want only the header", buf.String())
+	}
+}
+
+func TestWritersWriteNothingUntilUsed(t *testing.T) {
+	for _, format := range OutputFormats {
+		var buf bytes.Buffer
+		if _, err := NewResultWriter(&buf, format, true); err != nil {
+			t.Fatalf("NewResultWriter(%s) returned error: %v", format, err)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("%s writer wrote %q before any result or Close", format, buf.String())
+		}
+	}
+}
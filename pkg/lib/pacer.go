@@ -0,0 +1,128 @@
+package lib
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// Pacer spaces probes out to a global rate and an optional rate per subnet,
+// and caps the probes in flight with a ConnBudget. Subnets are /24s for IPv4
+// and /64s for IPv6. A nil Pacer never waits.
+type Pacer struct {
+	global     *rateLimiter
+	subnetRate int
+	budget     *ConnBudget
+
+	mutex   sync.Mutex
+	subnets map[netip.Prefix]*rateLimiter
+}
+
+// NewPacer returns a Pacer allowing rate probes per second overall and
+// subnetRate probes per second to any one subnet, with 0 meaning unlimited.
+// It returns nil when there is nothing to limit.
+func NewPacer(rate int, subnetRate int, budget *ConnBudget) *Pacer {
+	if rate <= 0 && subnetRate <= 0 && budget == nil {
+		return nil
+	}
+
+	return &Pacer{
+		global:     newRateLimiter(rate),
+		subnetRate: subnetRate,
+		budget:     budget,
+		subnets:    map[netip.Prefix]*rateLimiter{},
+	}
+}
+
+// Wait blocks until another probe may be sent to addr or ctx is done. addr may
+// be the zero Addr when only the global rate applies.
+func (p *Pacer) Wait(ctx context.Context, addr netip.Addr) error {
+	if p == nil {
+		return nil
+	}
+
+	if err := p.global.wait(ctx); err != nil {
+		return err
+	}
+	return p.subnet(addr).wait(ctx)
+}
+
+// Acquire waits like Wait and then for a free slot in the budget. Every
+// successful Acquire must be followed by a Release.
+func (p *Pacer) Acquire(ctx context.Context, addr netip.Addr) error {
+	if p == nil {
+		return nil
+	}
+
+	if err := p.Wait(ctx, addr); err != nil {
+		return err
+	}
+	return p.budget.Acquire(ctx)
+}
+
+func (p *Pacer) Release() {
+	if p == nil {
+		return
+	}
+	p.budget.Release()
+}
+
+func (p *Pacer) subnet(addr netip.Addr) *rateLimiter {
+	prefix, ok := subnetOf(addr)
+	if p.subnetRate <= 0 || !ok {
+		return nil
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	limiter, ok := p.subnets[prefix]
+	if !ok {
+		limiter = newRateLimiter(p.subnetRate)
+		p.subnets[prefix] = limiter
+	}
+	return limiter
+}
+
+// rateLimiter hands out evenly spaced slots, so probes are paced rather than
+// sent in bursts. A nil rateLimiter never waits.
+type rateLimiter struct {
+	mutex    sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newRateLimiter(perSecond int) *rateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Second / time.Duration(perSecond)}
+}
+
+func (r *rateLimiter) wait(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+
+	r.mutex.Lock()
+	now := time.Now()
+	if r.next.Before(now) {
+		r.next = now
+	}
+	delay := r.next.Sub(now)
+	r.next = r.next.Add(r.interval)
+	r.mutex.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,293 @@
+package lib
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	protocolICMP = 1
+
+	// address mask messages are deprecated and missing from ipv4
+	icmpTypeAddressMask      ipv4.ICMPType = 17
+	icmpTypeAddressMaskReply ipv4.ICMPType = 18
+)
+
+// ACKPingPorts are the ports HostRespondsToACK probes when the target has no
+// ports of its own.
+var ACKPingPorts = []int{80, 443}
+
+// pingProtocols are the IP protocols HostRespondsToProtocolPing sends
+// packets for: ICMP, IGMP and IP-in-IP, the same as nmap.
+var pingProtocols = []int{1, 2, 4}
+
+// pingProber runs one of the raw socket pings below, which get through
+// networks that drop echo requests and SYNs to closed ports. They all need
+// root or CAP_NET_RAW.
+type pingProber struct {
+	name          string
+	cost          int
+	timeoutMillis int
+	pacer         *Pacer
+	rtt           *RTTEstimator
+	ping          func(ctx context.Context, target Target, timeoutMillis int) (Evidence, error)
+}
+
+func (p *pingProber) Name() string {
+	return p.name
+}
+
+func (p *pingProber) Cost() int {
+	return p.cost
+}
+
+func (p *pingProber) Probe(ctx context.Context, target Target) (Evidence, error) {
+	if err := p.pacer.Acquire(ctx, target.Addr); err != nil {
+		return Evidence{}, err
+	}
+	defer p.pacer.Release()
+
+	evidence, err := p.ping(ctx, target, p.rtt.TimeoutMillis(target.Addr, p.timeoutMillis))
+	if evidence.Alive {
+		p.rtt.Observe(target.Addr, evidence.Latency)
+	}
+	return evidence, err
+}
+
+// HostRespondsToACK sends an unsolicited TCP ACK to each port (nmap -PA). A
+// live host answers with a RST whether the port is open or closed, and
+// stateless firewalls that drop SYNs often let ACKs through.
+func HostRespondsToACK(ctx context.Context, host string, ports []int, timeoutMillisTCP int) (Evidence, error) {
+	addr, err := resolveTargetAddr(ctx, host, "ip")
+	if err != nil || !addr.IsValid() {
+		return noSuchHost(err)
+	}
+
+	network, local := "ip4:tcp", "0.0.0.0"
+	if addr.Is6() {
+		network, local = "ip6:tcp", "::"
+	}
+	conn, err := net.ListenPacket(network, local)
+	if err != nil {
+		return Evidence{}, err
+	}
+	defer conn.Close()
+
+	if addr.Is6() {
+		// the kernel fills in IPv6 checksums when asked to
+		if err := ipv6.NewPacketConn(conn).SetChecksum(true, 16); err != nil {
+			return Evidence{}, err
+		}
+	}
+
+	src, err := sourceAddr(addr)
+	if err != nil {
+		return Evidence{}, err
+	}
+
+	srcPort := uint16(synPortBase + rand.IntN(synPortCount))
+	ack := rand.Uint32()
+	start := time.Now()
+	for _, port := range ports {
+		segment := tcpSegment(src, addr, srcPort, uint16(port), rand.Uint32(), ack, tcpFlagACK)
+		if _, err := conn.WriteTo(segment, icmpAddr(network, addr)); err != nil {
+			return Evidence{}, err
+		}
+	}
+
+	deadline := start.Add(time.Duration(timeoutMillisTCP) * time.Millisecond)
+	buf := make([]byte, 1500)
+	for ctx.Err() == nil && time.Now().Before(deadline) {
+		conn.SetReadDeadline(pollDeadline(deadline))
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			continue
+		}
+
+		// a RST to an ACK takes its sequence number from the ACK
+		from, ok := peerAddr(peer)
+		port, dstPort, seq, _, flags, valid := parseTCPReply(buf[:n])
+		if !ok || !valid || from.WithZone("") != addr.WithZone("") || dstPort != srcPort || flags&tcpFlagRST == 0 || seq != ack || !slices.Contains(ports, int(port)) {
+			continue
+		}
+
+		return Evidence{Alive: true, Port: int(port), Reason: ReasonReset, Latency: time.Since(start)}, nil
+	}
+
+	return Evidence{Reason: ReasonNoResponse}, nil
+}
+
+// HostRespondsToTimestamp sends an ICMP timestamp request (nmap -PP), which
+// hosts that filter echo requests often still answer. IPv4 only.
+func HostRespondsToTimestamp(ctx context.Context, host string, timeoutMillisICMP int) (Evidence, error) {
+	// identifier, sequence, then the originate, receive and transmit times
+	body := make([]byte, 16)
+	now := time.Now().UTC()
+	binary.BigEndian.PutUint32(body[4:], uint32(now.Sub(now.Truncate(24*time.Hour)).Milliseconds()))
+
+	return icmpQuery(ctx, host, timeoutMillisICMP, ipv4.ICMPTypeTimestamp, ipv4.ICMPTypeTimestampReply, body, ReasonTimestampReply)
+}
+
+// HostRespondsToAddressMask sends an ICMP address mask request (nmap -PM).
+// Few modern hosts answer, but old routers and embedded devices still do.
+// IPv4 only.
+func HostRespondsToAddressMask(ctx context.Context, host string, timeoutMillisICMP int) (Evidence, error) {
+	// identifier, sequence, then the address mask
+	body := make([]byte, 8)
+
+	return icmpQuery(ctx, host, timeoutMillisICMP, icmpTypeAddressMask, icmpTypeAddressMaskReply, body, ReasonAddressMaskReply)
+}
+
+// icmpQuery sends a single ICMP request whose body starts with an identifier
+// and sequence number, and waits for the matching reply.
+func icmpQuery(ctx context.Context, host string, timeoutMillis int, request, reply ipv4.ICMPType, body []byte, reason string) (Evidence, error) {
+	addr, err := resolveTargetAddr(ctx, host, "ip4")
+	if err != nil || !addr.Is4() {
+		return noSuchHost(err)
+	}
+
+	conn, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return Evidence{}, err
+	}
+	defer conn.Close()
+
+	id := uint16(rand.Uint32())
+	binary.BigEndian.PutUint16(body[0:], id)
+	binary.BigEndian.PutUint16(body[2:], 1)
+	msg, err := (&icmp.Message{Type: request, Body: &icmp.RawBody{Data: body}}).Marshal(nil)
+	if err != nil {
+		return Evidence{}, err
+	}
+
+	start := time.Now()
+	if _, err := conn.WriteTo(msg, icmpAddr("ip4:icmp", addr)); err != nil {
+		return Evidence{}, err
+	}
+
+	return awaitICMP(ctx, conn, addr, start, timeoutMillis, func(msg *icmp.Message) string {
+		data, ok := msg.Body.(*icmp.RawBody)
+		if msg.Type != reply || !ok || len(data.Data) < 2 || binary.BigEndian.Uint16(data.Data) != id {
+			return ""
+		}
+		return reason
+	})
+}
+
+// HostRespondsToProtocolPing sends a packet for each of a few IP protocols
+// (nmap -PO). A host answers the protocols it doesn't speak with an ICMP
+// protocol unreachable, and the ICMP one with an echo reply. IPv4 only.
+func HostRespondsToProtocolPing(ctx context.Context, host string, timeoutMillisICMP int) (Evidence, error) {
+	addr, err := resolveTargetAddr(ctx, host, "ip4")
+	if err != nil || !addr.Is4() {
+		return noSuchHost(err)
+	}
+
+	conn, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return Evidence{}, err
+	}
+	defer conn.Close()
+
+	id := uint16(rand.Uint32())
+	start := time.Now()
+	for _, protocol := range pingProtocols {
+		if err := sendProtocolPing(conn, addr, protocol, id); err != nil {
+			return Evidence{}, err
+		}
+	}
+
+	return awaitICMP(ctx, conn, addr, start, timeoutMillisICMP, func(msg *icmp.Message) string {
+		switch body := msg.Body.(type) {
+		case *icmp.Echo:
+			if msg.Type == ipv4.ICMPTypeEchoReply && body.ID == int(id) {
+				return ReasonEchoReply
+			}
+		case *icmp.DstUnreach:
+			// the original IPv4 header comes back in the body
+			header := body.Data
+			if msg.Code == 2 && len(header) >= 20 && netip.AddrFrom4([4]byte(header[16:20])) == addr && slices.Contains(pingProtocols, int(header[9])) {
+				return ReasonProtoUnreachable
+			}
+		}
+		return ""
+	})
+}
+
+// sendProtocolPing sends the packet for one protocol. ICMP gets an echo
+// request and IGMP a membership query, everything else is sent empty.
+func sendProtocolPing(conn *icmp.PacketConn, addr netip.Addr, protocol int, id uint16) error {
+	if protocol == protocolICMP {
+		msg, err := (&icmp.Message{Type: ipv4.ICMPTypeEcho, Body: &icmp.Echo{ID: int(id), Seq: 1}}).Marshal(nil)
+		if err != nil {
+			return err
+		}
+		_, err = conn.WriteTo(msg, icmpAddr("ip4:icmp", addr))
+		return err
+	}
+
+	raw, err := net.ListenPacket("ip4:"+strconv.Itoa(protocol), "0.0.0.0")
+	if err != nil {
+		return err
+	}
+	defer raw.Close()
+
+	var payload []byte
+	if protocol == 2 {
+		payload = []byte{0x11, 0, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint16(payload[2:], ipChecksum(payload))
+	}
+	_, err = raw.WriteTo(payload, icmpAddr("ip4", addr))
+	return err
+}
+
+// awaitICMP reads ICMP messages from addr until match returns a reason or the
+// timeout passes.
+func awaitICMP(ctx context.Context, conn *icmp.PacketConn, addr netip.Addr, start time.Time, timeoutMillis int, match func(msg *icmp.Message) string) (Evidence, error) {
+	deadline := start.Add(time.Duration(timeoutMillis) * time.Millisecond)
+	buf := make([]byte, 1500)
+	for ctx.Err() == nil && time.Now().Before(deadline) {
+		conn.SetReadDeadline(pollDeadline(deadline))
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			continue
+		}
+
+		from, ok := peerAddr(peer)
+		if !ok || from.WithZone("") != addr {
+			continue
+		}
+
+		msg, err := icmp.ParseMessage(protocolICMP, buf[:n])
+		if err != nil {
+			continue
+		}
+
+		if reason := match(msg); reason != "" {
+			return Evidence{Alive: true, Reason: reason, Latency: time.Since(start)}, nil
+		}
+	}
+
+	return Evidence{Reason: ReasonNoResponse}, nil
+}
+
+// noSuchHost turns a failed lookup into evidence. Targets a ping doesn't apply
+// to, such as IPv6 hosts for the ICMPv4 pings, get empty evidence.
+func noSuchHost(err error) (Evidence, error) {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return Evidence{Reason: ReasonNoSuchHost}, nil
+	}
+	return Evidence{}, err
+}
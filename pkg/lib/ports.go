@@ -2,10 +2,13 @@ package lib
 
 import (
 	"bufio"
+	"fmt"
 	"os"
 	"regexp"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
 )
 
 type portInfo struct {
@@ -33,8 +36,10 @@ func GetPopularPorts(protocol string) []int {
 	return popularPorts[protocol]
 }
 
+// GetTopPopularPorts returns up to count of the most popular ports.
 func GetTopPopularPorts(protocol string, count int) []int {
-	return GetPopularPorts(protocol)[:count]
+	ports := GetPopularPorts(protocol)
+	return ports[:max(0, min(count, len(ports)))]
 }
 
 func getNmapPortData(protocol string) ([]int, error) {
@@ -84,3 +89,62 @@ func getNmapPortData(protocol string) ([]int, error) {
 
 	return portsByWeight, nil
 }
+
+var (
+	serviceOnce  sync.Once
+	serviceNames map[string]string
+	servicePorts map[string][]int
+)
+
+func loadServices() {
+	serviceOnce.Do(func() {
+		serviceNames = map[string]string{}
+		servicePorts = map[string][]int{}
+		for _, path := range []string{"/usr/share/nmap/nmap-services", "/etc/services"} {
+			loadServiceNames(path, serviceNames, servicePorts)
+		}
+	})
+}
+
+// ServiceName returns the name nmap gives the service on port, falling back
+// to /etc/services when nmap is not installed.
+func ServiceName(protocol string, port int) string {
+	loadServices()
+	return serviceNames[fmt.Sprintf("%d/%s", port, protocol)]
+}
+
+// ServicePorts returns every port registered under the service name.
+func ServicePorts(protocol string, name string) []int {
+	loadServices()
+	return servicePorts[fmt.Sprintf("%s/%s", strings.ToLower(name), protocol)]
+}
+
+// loadServiceNames reads a services file in either the nmap-services or the
+// /etc/services format. Names already present are kept.
+func loadServiceNames(path string, names map[string]string, ports map[string][]int) {
+	servicesFile, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer servicesFile.Close()
+
+	scanner := bufio.NewScanner(servicesFile)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] == "unknown" {
+			continue
+		}
+
+		if _, ok := names[fields[1]]; ok {
+			continue
+		}
+		names[fields[1]] = fields[0]
+
+		portText, protocol, _ := strings.Cut(fields[1], "/")
+		if port, err := strconv.Atoi(portText); err == nil {
+			key := fmt.Sprintf("%s/%s", strings.ToLower(fields[0]), protocol)
+			ports[key] = append(ports[key], port)
+		}
+	}
+}
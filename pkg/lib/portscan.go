@@ -0,0 +1,167 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultPortConcurrency is how many ports on a single host are dialed at once
+// when ScanOptions.Concurrency is not set.
+var DefaultPortConcurrency = 100
+
+// ScanOptions configures a port scan. Budget and Pacer are optional and can be
+// shared between scans of several hosts to cap the total connections in flight
+// and the rate they are made at. RTT adapts the TCP timeout when set, and SYN
+// scans TCP ports with raw SYNs instead of full connections.
+type ScanOptions struct {
+	TimeoutTCPMillis int
+	TimeoutUDPMillis int
+	Concurrency      int
+	Budget           *ConnBudget
+	Pacer            *Pacer
+	RTT              *RTTEstimator
+	SYN              *SYNScanner
+}
+
+// PortResult is the state of a single port on a host.
+type PortResult struct {
+	Port     int       `json:"port"`
+	Protocol string    `json:"protocol"`
+	State    PortState `json:"state"`
+	Service  string    `json:"service,omitempty"`
+}
+
+// GetOpenPortsOnHost dials ports on host in parallel and returns the open
+// ones in ascending order. Cancelling ctx stops the scan and returns the ports
+// found so far.
+func GetOpenPortsOnHost(ctx context.Context, host string, ports []int, opts ScanOptions) []int {
+	openPorts := []int{}
+	for _, result := range scanTCPPorts(ctx, host, ports, opts) {
+		if result.State == PortOpen {
+			openPorts = append(openPorts, result.Port)
+		}
+	}
+
+	return openPorts
+}
+
+// ScanPorts checks every port in spec on host and returns the state of each,
+// TCP ports first, each protocol in ascending port order. Ports not reached
+// before ctx is cancelled are left out.
+func ScanPorts(ctx context.Context, host string, spec PortSpec, opts ScanOptions) []PortResult {
+	results := scanTCPPorts(ctx, host, spec.TCP, opts)
+	return append(results, scanUDPPorts(ctx, host, spec.UDP, opts)...)
+}
+
+func scanTCPPorts(ctx context.Context, host string, ports []int, opts ScanOptions) []PortResult {
+	addr, _ := netip.ParseAddr(host)
+	if opts.SYN != nil && addr.IsValid() {
+		return scanTCPPortsSYN(ctx, addr, ports, opts)
+	}
+
+	return scanConcurrently(ctx, host, ports, opts, func(port int) PortResult {
+		start := time.Now()
+		err := makeTCPConnection(ctx, host, opts.RTT.TimeoutMillis(addr, opts.TimeoutTCPMillis), port)
+		state := ClassifyDialError(err)
+		if state == PortOpen || state == PortClosed {
+			opts.RTT.Observe(addr, time.Since(start))
+		}
+		return PortResult{port, "tcp", state, ServiceName("tcp", port)}
+	})
+}
+
+func scanUDPPorts(ctx context.Context, host string, ports []int, opts ScanOptions) []PortResult {
+	timeout := time.Duration(opts.TimeoutUDPMillis) * time.Millisecond
+
+	return scanConcurrently(ctx, host, ports, opts, func(port int) PortResult {
+		result := PortResult{port, "udp", PortOpenFiltered, ServiceName("udp", port)}
+
+		d := net.Dialer{Timeout: timeout}
+		conn, err := d.DialContext(ctx, "udp", net.JoinHostPort(host, fmt.Sprint(port)))
+		if err != nil {
+			result.State = ClassifyDialError(err)
+			return result
+		}
+		defer conn.Close()
+
+		// closing the socket unblocks the read when ctx is cancelled
+		stop := context.AfterFunc(ctx, func() { conn.Close() })
+		defer stop()
+
+		evidence := sendUDPProbe(conn, port, timeout)
+		switch evidence.Reason {
+		case ReasonUDPResponse:
+			result.State = PortOpen
+		case ReasonPortUnreachable:
+			result.State = PortClosed
+		case ReasonHostUnreachable, ReasonNetUnreachable:
+			result.State = PortUnreachable
+		}
+		return result
+	})
+}
+
+// scanConcurrently runs scan over ports with opts.Concurrency workers. Once ctx
+// is cancelled no more ports are started.
+func scanConcurrently(ctx context.Context, host string, ports []int, opts ScanOptions, scan func(port int) PortResult) []PortResult {
+	addr, _ := netip.ParseAddr(host)
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultPortConcurrency
+	}
+	concurrency = min(concurrency, len(ports))
+
+	jobs := make(chan int)
+	results := []PortResult{}
+	var (
+		wg    sync.WaitGroup
+		mutex sync.Mutex
+	)
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for port := range jobs {
+				if opts.Pacer.Wait(ctx, addr) != nil || opts.Budget.Acquire(ctx) != nil {
+					continue
+				}
+				result := scan(port)
+				opts.Budget.Release()
+				if ctx.Err() != nil {
+					continue
+				}
+
+				mutex.Lock()
+				results = append(results, result)
+				mutex.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, port := range ports {
+		select {
+		case jobs <- port:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	sortPortResults(results)
+	return results
+}
+
+func sortPortResults(results []PortResult) {
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Port < results[j].Port
+	})
+}
@@ -0,0 +1,182 @@
+package lib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const maxPort = 65535
+
+// PortSpec is a set of TCP and UDP ports to scan. Each list is de-duplicated
+// and keeps the order ports were first given in, so popular ports selected
+// with top:N are still tried most popular first.
+type PortSpec struct {
+	TCP []int
+	UDP []int
+}
+
+// ParsePortSpec parses an nmap style port list. Entries are separated by
+// commas and may be:
+//
+//	22          a single port
+//	80-90       an inclusive range, 1024- runs to 65535
+//	http        every port registered for a service name, using the other
+//	            protocol when the service is not registered for this one
+//	top:200     the most popular ports
+//	-25         a port or range to exclude from the result
+//
+// Ports are TCP unless a U: prefix switches the entries that follow it to UDP;
+// T: switches back. For example "22,80-90,T:443,U:53,161,http,ssh,top:200,-25".
+// Empty entries, and specs that select no ports at all, are errors.
+func ParsePortSpec(spec string) (PortSpec, error) {
+	included := map[string]*portSet{"tcp": newPortSet(), "udp": newPortSet()}
+	excluded := map[string]*portSet{"tcp": newPortSet(), "udp": newPortSet()}
+	protocol := "tcp"
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if prefix, rest, ok := strings.Cut(entry, ":"); ok {
+			switch strings.ToUpper(prefix) {
+			case "T":
+				protocol = "tcp"
+				entry = rest
+			case "U":
+				protocol = "udp"
+				entry = rest
+			}
+		}
+
+		if entry == "" {
+			return PortSpec{}, fmt.Errorf("empty entry in port spec: %s", spec)
+		}
+
+		target := included
+		if rest, ok := strings.CutPrefix(entry, "-"); ok {
+			if rest == "" {
+				return PortSpec{}, fmt.Errorf("empty exclusion in port spec: %s", spec)
+			}
+			target = excluded
+			entry = rest
+		}
+
+		entryProtocol, ports, err := parsePortEntry(protocol, entry)
+		if err != nil {
+			return PortSpec{}, err
+		}
+		target[entryProtocol].add(ports...)
+	}
+
+	ports := PortSpec{
+		TCP: included["tcp"].without(excluded["tcp"]),
+		UDP: included["udp"].without(excluded["udp"]),
+	}
+	if len(ports.TCP) == 0 && len(ports.UDP) == 0 {
+		return PortSpec{}, fmt.Errorf("port spec selects no ports: %s", spec)
+	}
+	return ports, nil
+}
+
+func parsePortEntry(protocol string, entry string) (string, []int, error) {
+	if count, ok := strings.CutPrefix(strings.ToLower(entry), "top:"); ok {
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 1 {
+			return "", nil, fmt.Errorf("invalid top port count in port spec: %s", entry)
+		}
+		return protocol, GetTopPopularPorts(protocol, n), nil
+	}
+
+	if entry[0] < '0' || entry[0] > '9' {
+		if ports := ServicePorts(protocol, entry); len(ports) > 0 {
+			return protocol, ports, nil
+		}
+
+		other := "udp"
+		if protocol == "udp" {
+			other = "tcp"
+		}
+		if ports := ServicePorts(other, entry); len(ports) > 0 {
+			return other, ports, nil
+		}
+
+		return "", nil, fmt.Errorf("unknown service in port spec: %s", entry)
+	}
+
+	low, high, err := parsePortRange(entry)
+	if err != nil {
+		return "", nil, err
+	}
+
+	ports := make([]int, 0, high-low+1)
+	for port := low; port <= high; port++ {
+		ports = append(ports, port)
+	}
+	return protocol, ports, nil
+}
+
+func parsePortRange(entry string) (int, int, error) {
+	lowText, highText, isRange := strings.Cut(entry, "-")
+	low, err := strconv.Atoi(lowText)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port in port spec: %s", entry)
+	}
+
+	high := low
+	if isRange {
+		high = maxPort
+		if highText != "" {
+			high, err = strconv.Atoi(highText)
+			if err != nil {
+				return 0, 0, fmt.Errorf("invalid port in port spec: %s", entry)
+			}
+		}
+	}
+
+	if low < 1 || high > maxPort {
+		return 0, 0, fmt.Errorf("port out of range 1-%d in port spec: %s", maxPort, entry)
+	}
+
+	if low > high {
+		return 0, 0, fmt.Errorf("port range is backwards in port spec: %s", entry)
+	}
+
+	return low, high, nil
+}
+
+// portSet is an insertion ordered set of ports.
+type portSet struct {
+	ports []int
+	seen  map[int]bool
+}
+
+func newPortSet() *portSet {
+	return &portSet{seen: map[int]bool{}}
+}
+
+func (s *portSet) add(ports ...int) {
+	for _, port := range ports {
+		if !s.seen[port] {
+			s.seen[port] = true
+			s.ports = append(s.ports, port)
+		}
+	}
+}
+
+func (s *portSet) without(excluded *portSet) []int {
+	ports := []int{}
+	for _, port := range s.ports {
+		if !excluded.seen[port] {
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
+// uniquePorts concatenates lists of ports, keeping the first of any duplicates.
+func uniquePorts(lists ...[]int) []int {
+	set := newPortSet()
+	for _, ports := range lists {
+		set.add(ports...)
+	}
+	return set.ports
+}
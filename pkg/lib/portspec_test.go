@@ -0,0 +1,78 @@
+package lib
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParsePortSpec(t *testing.T) {
+	tests := []struct {
+		spec string
+		tcp  []int
+		udp  []int
+	}{
+		{"22", []int{22}, nil},
+		{"22,80-82", []int{22, 80, 81, 82}, nil},
+		{"65533-", []int{65533, 65534, 65535}, nil},
+		{"80,22,80", []int{80, 22}, nil},
+		{"20-25,-22-23", []int{20, 21, 24, 25}, nil},
+		{"-22,20-23", []int{20, 21, 23}, nil},
+		{"U:53,161", nil, []int{53, 161}},
+		{"22,U:53,T:80", []int{22, 80}, []int{53}},
+		{"u:53,t:22", []int{22}, []int{53}},
+		{"U:53-54,-54", nil, []int{53}},
+		{" 22 , 80 ", []int{22, 80}, nil},
+	}
+
+	for _, test := range tests {
+		spec, err := ParsePortSpec(test.spec)
+		if err != nil {
+			t.Errorf("ParsePortSpec(%q) returned error: %v", test.spec, err)
+			continue
+		}
+		if !slices.Equal(spec.TCP, test.tcp) && !(len(spec.TCP) == 0 && len(test.tcp) == 0) {
+			t.Errorf("ParsePortSpec(%q).TCP = %v, want %v", test.spec, spec.TCP, test.tcp)
+		}
+		if !slices.Equal(spec.UDP, test.udp) && !(len(spec.UDP) == 0 && len(test.udp) == 0) {
+			t.Errorf("ParsePortSpec(%q).UDP = %v, want %v", test.spec, spec.UDP, test.udp)
+		}
+	}
+}
+
+func TestParsePortSpecTop(t *testing.T) {
+	spec, err := ParsePortSpec("top:10,U:top:5")
+	if err != nil {
+		t.Fatalf("ParsePortSpec returned error: %v", err)
+	}
+	if len(spec.TCP) != 10 || len(spec.UDP) != 5 {
+		t.Errorf("got %d TCP and %d UDP ports, want 10 and 5", len(spec.TCP), len(spec.UDP))
+	}
+}
+
+func TestParsePortSpecErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"-",
+		"22,-",
+		"U:-",
+		"U:",
+		"T:",
+		"22,",
+		"22,,80",
+		"0",
+		"65536",
+		"90-80",
+		"22-x",
+		"top:0",
+		"top:x",
+		"no-such-service-name",
+		"22,-22",
+		"U:53,-53",
+	}
+
+	for _, spec := range tests {
+		if _, err := ParsePortSpec(spec); err == nil {
+			t.Errorf("ParsePortSpec(%q) returned no error", spec)
+		}
+	}
+}
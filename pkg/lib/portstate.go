@@ -0,0 +1,89 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// PortState is what a connection attempt revealed about a port.
+type PortState int
+
+const (
+	PortOpen PortState = iota
+	PortClosed
+	PortFiltered
+	PortUnreachable
+	PortError
+	PortOpenFiltered
+)
+
+func (s PortState) String() string {
+	switch s {
+	case PortOpen:
+		return "open"
+	case PortClosed:
+		return "closed"
+	case PortFiltered:
+		return "filtered"
+	case PortUnreachable:
+		return "unreachable"
+	case PortOpenFiltered:
+		return "open|filtered"
+	}
+	return "error"
+}
+
+func (s PortState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *PortState) UnmarshalText(text []byte) error {
+	for state := PortOpen; state <= PortOpenFiltered; state++ {
+		if state.String() == string(text) {
+			*s = state
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown port state %q", text)
+}
+
+// ClassifyDialError maps the error from a dial, read or write to the state of
+// the remote port. A nil error means the port is open.
+func ClassifyDialError(err error) PortState {
+	state, _ := classifyDialError(err)
+	return state
+}
+
+// classifyDialError also returns the reason to record as evidence.
+func classifyDialError(err error) (PortState, string) {
+	if err == nil {
+		return PortOpen, ReasonSynAck
+	}
+
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return PortClosed, ReasonReset
+	case errors.Is(err, syscall.EHOSTUNREACH):
+		return PortUnreachable, ReasonHostUnreachable
+	case errors.Is(err, syscall.ENETUNREACH):
+		return PortUnreachable, ReasonNetUnreachable
+	case errors.As(err, &dnsErr):
+		return PortUnreachable, ReasonNoSuchHost
+	case errors.Is(err, os.ErrDeadlineExceeded),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, context.Canceled):
+		return PortFiltered, ReasonNoResponse
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return PortFiltered, ReasonNoResponse
+	}
+
+	return PortError, ReasonError
+}
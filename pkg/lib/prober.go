@@ -0,0 +1,288 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Target is a single host handed to a Prober. Addr is set when Host is an IP
+// address. Name is the hostname Host was resolved from, if any. Ports pins the
+// ports probed on the host when the scope gave one, while KnownPorts are ports
+// an earlier scan found open, which are tried before the usual ones.
+type Target struct {
+	Host       string
+	Addr       netip.Addr
+	Name       string
+	Ports      []int
+	KnownPorts []int
+}
+
+// Prober is a single liveness check. Cost is a rough hint of how expensive a
+// probe is, so cheap methods can be tried before slow ones.
+type Prober interface {
+	Name() string
+	Cost() int
+	Probe(ctx context.Context, target Target) (Evidence, error)
+}
+
+// ProberConfig holds the settings shared by the built-in probers. Pacer, when
+// set, paces the ICMP and TCP probes, and RTT adapts their timeouts to the
+// round trip times observed so far. SYN makes the TCP prober send raw SYNs
+// instead of connecting, and ICMP makes the ICMP prober share one socket
+// rather than open one per host.
+type ProberConfig struct {
+	TimeoutICMPMillis  int
+	TimeoutTCPMillis   int
+	TimeoutUDPMillis   int
+	TimeoutARPMillis   int
+	TimeoutSweepMillis int
+	TCPPorts           []int
+	UDPPorts           []int
+	PrivilegedICMP     bool
+	Pacer              *Pacer
+	RTT                *RTTEstimator
+	SYN                *SYNScanner
+	ICMP               *ICMPSweeper
+}
+
+var DefaultProberConfig = ProberConfig{
+	TimeoutICMPMillis:  500,
+	TimeoutTCPMillis:   500,
+	TimeoutUDPMillis:   1000,
+	TimeoutARPMillis:   500,
+	TimeoutSweepMillis: 2000,
+}
+
+// Sweeper discovers hosts in prefixes that cannot be enumerated, sending a
+// HostResult for every responder it finds inside them.
+type Sweeper interface {
+	Name() string
+	Sweep(ctx context.Context, prefixes []netip.Prefix, results chan<- HostResult) error
+}
+
+// DefaultMethods are the probers used when none are requested explicitly.
+var DefaultMethods = []string{MethodICMP, MethodTCP}
+
+type ProberFactory func(cfg ProberConfig) (Prober, error)
+
+var (
+	proberMutex     sync.RWMutex
+	proberFactories = map[string]ProberFactory{}
+)
+
+// RegisterProber makes a prober available by name to NewProbers. Registering
+// an existing name replaces it.
+func RegisterProber(name string, factory ProberFactory) {
+	proberMutex.Lock()
+	defer proberMutex.Unlock()
+	proberFactories[strings.ToLower(name)] = factory
+}
+
+// ProberNames returns the names of every registered prober.
+func ProberNames() []string {
+	proberMutex.RLock()
+	defer proberMutex.RUnlock()
+
+	names := []string{}
+	for name := range proberFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewProbers builds the named probers in the order given. When names is empty
+// the DefaultMethods are used, ordered by cost.
+func NewProbers(names []string, cfg ProberConfig) ([]Prober, error) {
+	sortByCost := false
+	if len(names) == 0 {
+		names = DefaultMethods
+		sortByCost = true
+	}
+
+	proberMutex.RLock()
+	defer proberMutex.RUnlock()
+
+	probers := []Prober{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		factory, ok := proberFactories[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown discovery method: %s", name)
+		}
+
+		prober, err := factory(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create %s prober: %w", name, err)
+		}
+		probers = append(probers, prober)
+	}
+
+	if sortByCost {
+		sort.SliceStable(probers, func(i, j int) bool {
+			return probers[i].Cost() < probers[j].Cost()
+		})
+	}
+
+	return probers, nil
+}
+
+func init() {
+	RegisterProber(MethodICMP, func(cfg ProberConfig) (Prober, error) {
+		return &icmpProber{cfg.TimeoutICMPMillis, cfg.PrivilegedICMP, cfg.Pacer, cfg.RTT, cfg.ICMP}, nil
+	})
+	RegisterProber(MethodTCP, func(cfg ProberConfig) (Prober, error) {
+		ports := cfg.TCPPorts
+		if len(ports) == 0 {
+			ports = GetTopPopularPorts("tcp", 100)
+		}
+		return &tcpProber{cfg.TimeoutTCPMillis, ports, cfg.Pacer, cfg.RTT, cfg.SYN}, nil
+	})
+	RegisterProber(MethodUDP, func(cfg ProberConfig) (Prober, error) {
+		ports := cfg.UDPPorts
+		if len(ports) == 0 {
+			ports = GetTopPopularPorts("udp", 20)
+		}
+		return &udpProber{cfg.TimeoutUDPMillis, ports}, nil
+	})
+	RegisterProber(MethodARP, func(cfg ProberConfig) (Prober, error) {
+		return &arpProber{cfg.TimeoutARPMillis}, nil
+	})
+	RegisterProber(MethodNDP, func(cfg ProberConfig) (Prober, error) {
+		return &ndpProber{cfg.TimeoutARPMillis}, nil
+	})
+	RegisterProber(MethodACK, func(cfg ProberConfig) (Prober, error) {
+		return &pingProber{MethodACK, len(ACKPingPorts), cfg.TimeoutTCPMillis, cfg.Pacer, cfg.RTT, func(ctx context.Context, target Target, timeoutMillis int) (Evidence, error) {
+			ports := ACKPingPorts
+			if len(target.Ports) > 0 {
+				ports = target.Ports
+			} else if len(target.KnownPorts) > 0 {
+				ports = uniquePorts(target.KnownPorts, ports)
+			}
+			return HostRespondsToACK(ctx, target.Host, ports, timeoutMillis)
+		}}, nil
+	})
+	RegisterProber(MethodTimestamp, func(cfg ProberConfig) (Prober, error) {
+		return &pingProber{MethodTimestamp, 1, cfg.TimeoutICMPMillis, cfg.Pacer, cfg.RTT, func(ctx context.Context, target Target, timeoutMillis int) (Evidence, error) {
+			return HostRespondsToTimestamp(ctx, target.Host, timeoutMillis)
+		}}, nil
+	})
+	RegisterProber(MethodMask, func(cfg ProberConfig) (Prober, error) {
+		return &pingProber{MethodMask, 1, cfg.TimeoutICMPMillis, cfg.Pacer, cfg.RTT, func(ctx context.Context, target Target, timeoutMillis int) (Evidence, error) {
+			return HostRespondsToAddressMask(ctx, target.Host, timeoutMillis)
+		}}, nil
+	})
+	RegisterProber(MethodProto, func(cfg ProberConfig) (Prober, error) {
+		return &pingProber{MethodProto, len(pingProtocols), cfg.TimeoutICMPMillis, cfg.Pacer, cfg.RTT, func(ctx context.Context, target Target, timeoutMillis int) (Evidence, error) {
+			return HostRespondsToProtocolPing(ctx, target.Host, timeoutMillis)
+		}}, nil
+	})
+}
+
+type icmpProber struct {
+	timeoutMillis int
+	privileged    bool
+	pacer         *Pacer
+	rtt           *RTTEstimator
+	sweeper       *ICMPSweeper
+}
+
+func (p *icmpProber) Name() string {
+	return MethodICMP
+}
+
+func (p *icmpProber) Cost() int {
+	return 1
+}
+
+func (p *icmpProber) Probe(ctx context.Context, target Target) (Evidence, error) {
+	if err := p.pacer.Acquire(ctx, target.Addr); err != nil {
+		return Evidence{}, err
+	}
+	defer p.pacer.Release()
+
+	timeout := p.rtt.TimeoutMillis(target.Addr, p.timeoutMillis)
+	if p.sweeper == nil || !target.Addr.IsValid() {
+		return p.observe(target, HostRespondsToICMP(ctx, target.Host, timeout, p.privileged)), nil
+	}
+
+	evidence, err := p.sweeper.Ping(ctx, target.Addr, timeout)
+	return p.observe(target, evidence), err
+}
+
+func (p *icmpProber) observe(target Target, evidence Evidence) Evidence {
+	if evidence.Alive {
+		p.rtt.Observe(target.Addr, evidence.Latency)
+	}
+	return evidence
+}
+
+type tcpProber struct {
+	timeoutMillis int
+	ports         []int
+	pacer         *Pacer
+	rtt           *RTTEstimator
+	syn           *SYNScanner
+}
+
+func (p *tcpProber) Name() string {
+	return MethodTCP
+}
+
+func (p *tcpProber) Cost() int {
+	return len(p.ports)
+}
+
+func (p *tcpProber) Probe(ctx context.Context, target Target) (Evidence, error) {
+	ports := p.ports
+	if len(target.Ports) > 0 {
+		ports = target.Ports
+	} else if len(target.KnownPorts) > 0 {
+		ports = uniquePorts(target.KnownPorts, p.ports)
+	}
+
+	if p.syn != nil && target.Addr.IsValid() {
+		// the SYN engine paces every packet itself
+		evidence, err := HostHasOpenPortSYN(ctx, p.syn, target.Addr, ports, p.timeout(target))
+		return p.observe(target, evidence), err
+	}
+
+	if p.pacer == nil {
+		return p.observe(target, HostHasOpenPort(ctx, target.Host, ports, p.timeout(target))), nil
+	}
+
+	// paced connections are dialed one at a time so each waits its turn
+	for _, port := range ports {
+		if err := p.pacer.Acquire(ctx, target.Addr); err != nil {
+			return Evidence{}, err
+		}
+		evidence := HostHasOpenPort(ctx, target.Host, []int{port}, p.timeout(target))
+		p.pacer.Release()
+
+		if evidence.Alive || evidence.Reason != ReasonNoResponse {
+			return p.observe(target, evidence), nil
+		}
+	}
+	return Evidence{Reason: ReasonNoResponse}, nil
+}
+
+func (p *tcpProber) timeout(target Target) int {
+	return p.rtt.TimeoutMillis(target.Addr, p.timeoutMillis)
+}
+
+func (p *tcpProber) observe(target Target, evidence Evidence) Evidence {
+	if evidence.Alive {
+		p.rtt.Observe(target.Addr, evidence.Latency)
+	}
+	return evidence
+}
@@ -0,0 +1,153 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+var (
+	DefaultResolveConcurrency   = 10
+	DefaultResolveTimeoutMillis = 3000
+)
+
+// Resolver expands hostname targets into one target per A and AAAA record.
+type Resolver struct {
+	concurrency   int
+	timeoutMillis int
+	resolver      *net.Resolver
+}
+
+// NewResolver returns a Resolver that queries server, an address with an
+// optional port, or the system resolver when server is empty.
+func NewResolver(server string, concurrency, timeoutMillis int) *Resolver {
+	if concurrency <= 0 {
+		concurrency = DefaultResolveConcurrency
+	}
+	if timeoutMillis <= 0 {
+		timeoutMillis = DefaultResolveTimeoutMillis
+	}
+
+	resolver := net.DefaultResolver
+	if server != "" {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				d := net.Dialer{}
+				return d.DialContext(ctx, network, server)
+			},
+		}
+	}
+
+	return &Resolver{concurrency, timeoutMillis, resolver}
+}
+
+// Resolve yields targets with an address as they are, and looks up hostname
+// targets concurrently, yielding one target per record with Name set to the
+// hostname. Names that fail to resolve are yielded once with the lookup error.
+func (r *Resolver) Resolve(ctx context.Context, targets iter.Seq[Target]) iter.Seq2[Target, error] {
+	type resolved struct {
+		target Target
+		err    error
+	}
+
+	return func(yield func(Target, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		names := make(chan Target)
+		out := make(chan resolved)
+
+		send := func(target Target, err error) bool {
+			select {
+			case out <- resolved{target, err}:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < r.concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for target := range names {
+					addrs, err := r.lookup(ctx, target.Host)
+					if err != nil {
+						send(target, err)
+						continue
+					}
+
+					for _, addr := range addrs {
+						resolved := target
+						resolved.Host, resolved.Addr, resolved.Name = addr.String(), addr, target.Host
+						if !send(resolved, nil) {
+							break
+						}
+					}
+				}
+			}()
+		}
+
+		go func() {
+			defer func() {
+				close(names)
+				wg.Wait()
+				close(out)
+			}()
+
+			for target := range targets {
+				if target.Addr.IsValid() {
+					if !send(target, nil) {
+						return
+					}
+					continue
+				}
+
+				select {
+				case names <- target:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+
+		for result := range out {
+			if !yield(result.target, result.err) {
+				return
+			}
+		}
+	}
+}
+
+func (r *Resolver) lookup(ctx context.Context, host string) ([]netip.Addr, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.timeoutMillis)*time.Millisecond)
+	defer cancel()
+
+	addrs, err := r.resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range addrs {
+		addrs[i] = addrs[i].Unmap()
+	}
+	return addrs, nil
+}
+
+// resolveReason turns a lookup error into the reason recorded for the name.
+func resolveReason(err error) string {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return ReasonNoSuchHost
+	}
+	return ReasonResolveFailed
+}
@@ -0,0 +1,87 @@
+package lib
+
+import "time"
+
+const (
+	MethodICMP      = "icmp"
+	MethodTCP       = "tcp"
+	MethodUDP       = "udp"
+	MethodARP       = "arp"
+	MethodNDP       = "ndp"
+	MethodACK       = "ack"
+	MethodTimestamp = "timestamp"
+	MethodMask      = "mask"
+	MethodProto     = "proto"
+)
+
+// Reasons follow the naming nmap uses in its --reason output where possible.
+const (
+	ReasonEchoReply        = "echo-reply"
+	ReasonTimestampReply   = "timestamp-reply"
+	ReasonAddressMaskReply = "addressmask-reply"
+	ReasonSynAck           = "syn-ack"
+	ReasonReset            = "reset"
+	ReasonUDPResponse      = "udp-response"
+	ReasonPortUnreachable  = "port-unreach"
+	ReasonProtoUnreachable = "proto-unreach"
+	ReasonARPResponse      = "arp-response"
+	ReasonNDResponse       = "nd-response"
+	ReasonNoResponse       = "no-response"
+	ReasonHostUnreachable  = "host-unreach"
+	ReasonNetUnreachable   = "net-unreach"
+	ReasonNoSuchHost       = "no-such-host"
+	ReasonResolveFailed    = "resolve-failed"
+	ReasonExcluded         = "excluded"
+	ReasonError            = "error"
+)
+
+// Evidence is what a single probe observed about a host.
+type Evidence struct {
+	Alive   bool
+	Port    int
+	Reason  string
+	Latency time.Duration
+	MAC     string
+	Vendor  string
+}
+
+// HostResult is the outcome of checking a single host. For active hosts it
+// records the method and evidence that proved liveness, for inactive hosts
+// Reason explains why the host was judged down. Hostname is the scope name
+// Host was resolved from, and Ports holds the open ports found by a port scan.
+type HostResult struct {
+	Host     string        `json:"host"`
+	Hostname string        `json:"hostname,omitempty"`
+	Active   bool          `json:"active"`
+	Method   string        `json:"method,omitempty"`
+	Port     int           `json:"port,omitempty"`
+	Reason   string        `json:"reason"`
+	Latency  time.Duration `json:"latency_ns,omitempty"`
+	MAC      string        `json:"mac,omitempty"`
+	Vendor   string        `json:"vendor,omitempty"`
+	Attempt  int           `json:"attempt,omitempty"`
+	Time     time.Time     `json:"time"`
+	Ports    []PortResult  `json:"ports,omitempty"`
+}
+
+// record applies the evidence from a probe using method to the result and
+// reports whether it proved the host alive. Evidence from failed probes only
+// replaces the down reason when it is more specific than no response.
+func (r *HostResult) record(method string, evidence Evidence) bool {
+	if evidence.Alive {
+		r.Active = true
+		r.Method = method
+		r.Port = evidence.Port
+		r.Reason = evidence.Reason
+		r.Latency = evidence.Latency
+		r.MAC = evidence.MAC
+		r.Vendor = evidence.Vendor
+		r.Time = time.Now()
+		return true
+	}
+
+	if evidence.Reason != "" && evidence.Reason != ReasonNoResponse {
+		r.Reason = evidence.Reason
+	}
+	return false
+}
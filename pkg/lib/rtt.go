@@ -0,0 +1,105 @@
+package lib
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// RTTEstimator adapts probe timeouts to the round trip times seen so far, the
+// way TCP computes its retransmission timeout (RFC 6298). Estimates are kept
+// for each /24, or /64 for IPv6, and globally for subnets that haven't
+// answered yet. A nil RTTEstimator always uses the fixed timeout.
+type RTTEstimator struct {
+	min time.Duration
+	max time.Duration
+
+	mutex   sync.Mutex
+	global  rttStats
+	subnets map[netip.Prefix]*rttStats
+}
+
+type rttStats struct {
+	srtt    time.Duration
+	rttvar  time.Duration
+	samples int
+}
+
+func NewRTTEstimator(minMillis int, maxMillis int) *RTTEstimator {
+	return &RTTEstimator{
+		min:     time.Duration(minMillis) * time.Millisecond,
+		max:     time.Duration(maxMillis) * time.Millisecond,
+		subnets: map[netip.Prefix]*rttStats{},
+	}
+}
+
+// Observe records a round trip time measured to addr.
+func (e *RTTEstimator) Observe(addr netip.Addr, rtt time.Duration) {
+	if e == nil || rtt <= 0 {
+		return
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.global.observe(rtt)
+	if prefix, ok := subnetOf(addr); ok {
+		stats, ok := e.subnets[prefix]
+		if !ok {
+			stats = &rttStats{}
+			e.subnets[prefix] = stats
+		}
+		stats.observe(rtt)
+	}
+}
+
+// TimeoutMillis returns the timeout to use for a probe to addr, falling back
+// to fallbackMillis until any round trip has been observed.
+func (e *RTTEstimator) TimeoutMillis(addr netip.Addr, fallbackMillis int) int {
+	if e == nil {
+		return fallbackMillis
+	}
+
+	e.mutex.Lock()
+	stats := e.global
+	if prefix, ok := subnetOf(addr); ok && e.subnets[prefix] != nil {
+		stats = *e.subnets[prefix]
+	}
+	e.mutex.Unlock()
+
+	if stats.samples == 0 {
+		return fallbackMillis
+	}
+
+	timeout := min(max(stats.rto(), e.min), e.max)
+	return int(timeout.Milliseconds())
+}
+
+func (s *rttStats) observe(rtt time.Duration) {
+	if s.samples == 0 {
+		s.srtt = rtt
+		s.rttvar = rtt / 2
+	} else {
+		s.rttvar = (3*s.rttvar + (s.srtt - rtt).Abs()) / 4
+		s.srtt = (7*s.srtt + rtt) / 8
+	}
+	s.samples++
+}
+
+func (s rttStats) rto() time.Duration {
+	return s.srtt + 4*s.rttvar
+}
+
+// subnetOf returns the /24, or /64 for IPv6, that addr belongs to.
+func subnetOf(addr netip.Addr) (netip.Prefix, bool) {
+	if !addr.IsValid() {
+		return netip.Prefix{}, false
+	}
+
+	bits := 24
+	if addr.Is6() {
+		bits = 64
+	}
+	prefix, err := addr.Prefix(bits)
+	return prefix, err == nil
+}
@@ -0,0 +1,366 @@
+package lib
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MaxExpandHostBits is the largest number of host bits in an IPv6 prefix that
+// will be enumerated address by address. Larger IPv6 prefixes can never be
+// brute forced and are swept with neighbor discovery instead.
+var MaxExpandHostBits = 16
+
+// maxRangeSize caps dash ranges, which unlike CIDRs can span any number of
+// addresses.
+const maxRangeSize = 1 << 32
+
+// Scope is the set of targets to discover. Entries are only expanded into
+// individual targets as they are iterated, so large CIDRs cost no memory.
+// Prefixes are too large to enumerate and are handed to sweepers instead.
+// Wildcards holds the domains of *.domain entries, which are only probed once
+// ExpandWildcards has found hosts under them.
+type Scope struct {
+	entries        []scopeEntry
+	excludedRanges []addrRange
+	excludedNames  map[string]bool
+	Prefixes       []netip.Prefix
+	Wildcards      []string
+}
+
+// scopeEntry is a single host, or a set of addresses described by a prefix,
+// a dash range or an nmap style octet pattern. Ports pins the ports probed on
+// a single host, and name is the hostname a host was resolved from.
+// knownPorts are TCP ports an earlier scan found open on the host.
+type scopeEntry struct {
+	host       string
+	name       string
+	ports      []int
+	knownPorts []int
+	prefix     netip.Prefix
+	span       addrRange
+	octets     [4][]uint8
+}
+
+// ScopeError is a scope entry that could not be parsed.
+type ScopeError struct {
+	Line  int
+	Entry string
+	Err   error
+}
+
+func (e *ScopeError) Error() string {
+	return fmt.Sprintf("line %d: %q: %v", e.Line, e.Entry, e.Err)
+}
+
+func (e *ScopeError) Unwrap() error {
+	return e.Err
+}
+
+var dashSpacing = regexp.MustCompile(`\s*-\s*`)
+
+// ParseScope reads a scope with one or more entries per line. Blank lines and
+// anything after a # are ignored. Entries that can't be parsed are reported
+// as ScopeErrors joined into the returned error, and the rest of the scope is
+// still returned.
+func ParseScope(r io.Reader) (*Scope, error) {
+	scope := &Scope{}
+	errs := []error{}
+
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = dashSpacing.ReplaceAllString(strings.TrimSpace(line), "-")
+
+		for _, entry := range strings.Fields(line) {
+			if err := scope.Add(entry); err != nil {
+				errs = append(errs, &ScopeError{lineNumber, entry, err})
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return scope, errors.Join(errs...)
+}
+
+// Add parses a single scope entry. It may be an IP, hostname, CIDR, dash range
+// such as 10.0.0.1-10.0.0.50 or 10.0.0.1-50, or an nmap style octet pattern
+// such as 10.0.1-3.1-254. Single hosts may pin a port to probe with host:port,
+// using [addr]:port for IPv6. CIDRs are kept as prefixes and expanded lazily
+// by Targets. Wildcards such as *.example.com are kept in Wildcards.
+func (s *Scope) Add(entry string) error {
+	if strings.Contains(entry, "*") {
+		return s.addWildcard(entry)
+	}
+
+	entry, ports, err := splitHostPort(entry)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case strings.Contains(entry, "/"):
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return errors.New("invalid CIDR")
+		}
+		prefix = prefix.Masked()
+
+		if !canExpand(prefix) {
+			if !s.prefixExcluded(prefix) {
+				s.Prefixes = append(s.Prefixes, prefix)
+			}
+			return nil
+		}
+		s.entries = append(s.entries, scopeEntry{prefix: prefix, ports: ports})
+		return nil
+
+	case isOctetPattern(entry):
+		octets, err := parseOctetPattern(entry)
+		if err != nil {
+			return err
+		}
+		s.entries = append(s.entries, scopeEntry{octets: octets, ports: ports})
+		return nil
+
+	case strings.Contains(entry, "-") && strings.ContainsAny(strings.SplitN(entry, "-", 2)[0], ".:"):
+		if _, err := netip.ParseAddr(strings.SplitN(entry, "-", 2)[0]); err != nil {
+			break
+		}
+
+		span, err := parseAddrRange(entry)
+		if err != nil {
+			return err
+		}
+		if addrOffset(span.from, span.to) >= maxRangeSize {
+			return errors.New("range is too large")
+		}
+		s.entries = append(s.entries, scopeEntry{span: span, ports: ports})
+		return nil
+	}
+
+	if _, err := netip.ParseAddr(entry); err == nil || isHostname(entry) {
+		s.entries = append(s.entries, scopeEntry{host: entry, ports: ports})
+		return nil
+	}
+
+	return errors.New("not an IP, CIDR, range or hostname")
+}
+
+// splitHostPort separates a pinned port from an entry.
+func splitHostPort(entry string) (string, []int, error) {
+	var host, portText string
+	switch {
+	case strings.HasPrefix(entry, "["):
+		end := strings.Index(entry, "]")
+		if end < 0 {
+			return "", nil, errors.New("missing ]")
+		}
+		host = entry[1:end]
+		portText, _ = strings.CutPrefix(entry[end+1:], ":")
+	case strings.Count(entry, ":") == 1:
+		host, portText, _ = strings.Cut(entry, ":")
+	default:
+		return entry, nil, nil
+	}
+
+	if portText == "" {
+		return host, nil, nil
+	}
+
+	port, err := strconv.Atoi(portText)
+	if err != nil || port < 1 || port > maxPort {
+		return "", nil, fmt.Errorf("invalid port %q", portText)
+	}
+	return host, []int{port}, nil
+}
+
+var hostnamePattern = regexp.MustCompile(`^[A-Za-z0-9_]([A-Za-z0-9_-]*[A-Za-z0-9_])?(\.[A-Za-z0-9_]([A-Za-z0-9_-]*[A-Za-z0-9_])?)*\.?$`)
+
+var numericPattern = regexp.MustCompile(`^[0-9.]+$`)
+
+// isHostname reports whether entry is a valid hostname. Dotted numbers are not
+// hostnames, they are malformed IPs.
+func isHostname(entry string) bool {
+	return len(entry) <= 253 && hostnamePattern.MatchString(entry) && !numericPattern.MatchString(entry)
+}
+
+var octetPattern = regexp.MustCompile(`^[0-9,-]+\.[0-9,-]+\.[0-9,-]+\.[0-9,-]+$`)
+
+func isOctetPattern(entry string) bool {
+	return octetPattern.MatchString(entry) && strings.ContainsAny(entry, ",-")
+}
+
+// parseOctetPattern parses nmap style IPv4 octet ranges where each octet is a
+// comma separated list of values or inclusive ranges.
+func parseOctetPattern(entry string) ([4][]uint8, error) {
+	var octets [4][]uint8
+	for i, part := range strings.Split(entry, ".") {
+		seen := map[uint8]bool{}
+		for _, item := range strings.Split(part, ",") {
+			lowText, highText, isRange := strings.Cut(item, "-")
+			low, err := strconv.Atoi(lowText)
+			if err != nil || low < 0 || low > 255 {
+				return octets, fmt.Errorf("invalid octet %q", item)
+			}
+
+			high := low
+			if isRange {
+				high, err = strconv.Atoi(highText)
+				if err != nil || high < low || high > 255 {
+					return octets, fmt.Errorf("invalid octet range %q", item)
+				}
+			}
+
+			for value := low; value <= high; value++ {
+				if !seen[uint8(value)] {
+					seen[uint8(value)] = true
+					octets[i] = append(octets[i], uint8(value))
+				}
+			}
+		}
+	}
+	return octets, nil
+}
+
+// Count returns how many targets Targets will yield without expanding them.
+// Hosts found by sweeping Prefixes are not included.
+func (s *Scope) Count() int {
+	return s.count(true)
+}
+
+func (s *Scope) count(withExclusions bool) int {
+	count := 0
+	for _, entry := range s.entries {
+		if entry.host != "" {
+			if !withExclusions || !s.IsExcluded(entry.target()) {
+				count++
+			}
+			continue
+		}
+
+		for span := range entry.spans() {
+			count += int(addrOffset(span.from, span.to)) + 1
+			if withExclusions {
+				count -= s.excludedInRange(span.from, span.to)
+			}
+		}
+	}
+	return count
+}
+
+func (e scopeEntry) target() Target {
+	target := Target{Host: e.host, Name: e.name, Ports: e.ports, KnownPorts: e.knownPorts}
+	if addr, err := netip.ParseAddr(e.host); err == nil {
+		target.Addr = addr
+	}
+	return target
+}
+
+// spans yields the contiguous address ranges covered by an entry that is not
+// a single host.
+func (e scopeEntry) spans() iter.Seq[addrRange] {
+	return func(yield func(addrRange) bool) {
+		switch {
+		case e.prefix.IsValid():
+			if prefixHostCount(e.prefix) > 0 {
+				from, to := prefixHostRange(e.prefix)
+				yield(addrRange{from, to})
+			}
+
+		case e.span.from.IsValid():
+			yield(e.span)
+
+		default:
+			for _, a := range e.octets[0] {
+				for _, b := range e.octets[1] {
+					for _, c := range e.octets[2] {
+						if !yieldOctetRuns([3]uint8{a, b, c}, e.octets[3], yield) {
+							return
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// yieldOctetRuns yields a range for every run of consecutive values in last.
+func yieldOctetRuns(network [3]uint8, last []uint8, yield func(addrRange) bool) bool {
+	for i := 0; i < len(last); {
+		j := i
+		for j+1 < len(last) && last[j+1] == last[j]+1 {
+			j++
+		}
+
+		from := netip.AddrFrom4([4]byte{network[0], network[1], network[2], last[i]})
+		to := netip.AddrFrom4([4]byte{network[0], network[1], network[2], last[j]})
+		if !yield(addrRange{from, to}) {
+			return false
+		}
+		i = j + 1
+	}
+	return true
+}
+
+// Targets yields every host in the scope one at a time.
+func (s *Scope) Targets() iter.Seq[Target] {
+	return func(yield func(Target) bool) {
+		for _, entry := range s.entries {
+			if entry.host != "" {
+				target := entry.target()
+				if s.IsExcluded(target) {
+					continue
+				}
+
+				if !yield(target) {
+					return
+				}
+				continue
+			}
+
+			for span := range entry.spans() {
+				for addr := span.from; addr.IsValid() && !span.to.Less(addr); addr = addr.Next() {
+					if s.addrExcluded(addr) {
+						continue
+					}
+
+					if !yield(Target{Host: addr.String(), Addr: addr, Ports: entry.ports}) {
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+// prefixHostRange returns the usable addresses in prefix, skipping the
+// network and broadcast addresses.
+func prefixHostRange(prefix netip.Prefix) (netip.Addr, netip.Addr) {
+	if prefix.IsSingleIP() {
+		return prefix.Addr(), prefix.Addr()
+	}
+	r := prefixRange(prefix)
+	return r.from.Next(), r.to.Prev()
+}
+
+func prefixHostCount(prefix netip.Prefix) int {
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	if hostBits == 0 {
+		return 1
+	}
+	return 1<<hostBits - 2
+}
+
+func canExpand(prefix netip.Prefix) bool {
+	return prefix.Addr().Is4() || prefix.Addr().BitLen()-prefix.Bits() <= MaxExpandHostBits
+}
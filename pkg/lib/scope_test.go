@@ -0,0 +1,132 @@
+package lib
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseScope(t *testing.T) {
+	tests := []struct {
+		name  string
+		scope string
+		hosts []string
+		ports []int
+	}{
+		{"single host", "10.0.0.1", []string{"10.0.0.1"}, nil},
+		{"hostname", "app.example.com", []string{"app.example.com"}, nil},
+		{"CIDR skips network and broadcast", "10.0.0.0/30", []string{"10.0.0.1", "10.0.0.2"}, nil},
+		{"single address CIDR", "10.0.0.7/32", []string{"10.0.0.7"}, nil},
+		{"dash range", "10.0.0.1-10.0.0.3", []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, nil},
+		{"short dash range", "10.0.0.254-255", []string{"10.0.0.254", "10.0.0.255"}, nil},
+		{"spaced dash range", "10.0.0.1 - 10.0.0.2", []string{"10.0.0.1", "10.0.0.2"}, nil},
+		{"IPv6 dash range", "2001:db8::1-2001:db8::2", []string{"2001:db8::1", "2001:db8::2"}, nil},
+		{"IPv6 dash range across a /64", "2001:db8::ffff:ffff:ffff:ffff-2001:db8:0:1::", []string{"2001:db8::ffff:ffff:ffff:ffff", "2001:db8:0:1::"}, nil},
+		{"octet ranges", "10.0.1-2.1,3", []string{"10.0.1.1", "10.0.1.3", "10.0.2.1", "10.0.2.3"}, nil},
+		{"host and port", "10.0.0.5:8443", []string{"10.0.0.5"}, []int{8443}},
+		{"hostname and port", "app.example.com:8080", []string{"app.example.com"}, []int{8080}},
+		{"IPv6 and port", "[2001:db8::1]:443", []string{"2001:db8::1"}, []int{443}},
+		{"bracketed IPv6", "[2001:db8::1]", []string{"2001:db8::1"}, nil},
+		{"bare IPv6", "2001:db8::1", []string{"2001:db8::1"}, nil},
+		{"comments and blank lines", "# scope\n\n10.0.0.1 # first\n  \n10.0.0.2", []string{"10.0.0.1", "10.0.0.2"}, nil},
+		{"several entries per line", "10.0.0.1 10.0.0.2", []string{"10.0.0.1", "10.0.0.2"}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scope, err := ParseScope(strings.NewReader(test.scope))
+			if err != nil {
+				t.Fatalf("ParseScope returned error: %v", err)
+			}
+
+			hosts := []string{}
+			for target := range scope.Targets() {
+				hosts = append(hosts, target.Host)
+				if !slices.Equal(target.Ports, test.ports) {
+					t.Errorf("target %s has ports %v, want %v", target.Host, target.Ports, test.ports)
+				}
+			}
+
+			if !slices.Equal(hosts, test.hosts) {
+				t.Errorf("Targets() = %v, want %v", hosts, test.hosts)
+			}
+			if count := scope.Count(); count != len(test.hosts) {
+				t.Errorf("Count() = %d, want %d", count, len(test.hosts))
+			}
+		})
+	}
+}
+
+func TestParseScopeErrors(t *testing.T) {
+	scope, err := ParseScope(strings.NewReader("10.0.0.1\n10.0.0.300\n10.0.0.0/33 10.0.0.2\n10.0.0.9-10.0.0.1\n10.0.0.3:0\n[2001:db8::1\n10.0.1-300.1\n2001:db8::5-2001:db8:0:1::5\n"))
+	if err == nil {
+		t.Fatal("ParseScope returned no error")
+	}
+
+	var lines []int
+	for _, joined := range err.(interface{ Unwrap() []error }).Unwrap() {
+		if scopeErr, ok := joined.(*ScopeError); ok {
+			lines = append(lines, scopeErr.Line)
+		}
+	}
+	if want := []int{2, 3, 4, 5, 6, 7, 8}; !slices.Equal(lines, want) {
+		t.Errorf("errors on lines %v, want %v", lines, want)
+	}
+
+	if count := scope.Count(); count != 2 {
+		t.Errorf("Count() = %d, want the 2 valid hosts", count)
+	}
+}
+
+func TestScopeAdd(t *testing.T) {
+	scope := &Scope{}
+	for _, entry := range []string{"*.example.com", "2001:db8::/64", "2001:db8::/112"} {
+		if err := scope.Add(entry); err != nil {
+			t.Fatalf("Add(%q) returned error: %v", entry, err)
+		}
+	}
+
+	if !slices.Equal(scope.Wildcards, []string{"example.com"}) {
+		t.Errorf("Wildcards = %v, want [example.com]", scope.Wildcards)
+	}
+	if len(scope.Prefixes) != 1 || scope.Prefixes[0].String() != "2001:db8::/64" {
+		t.Errorf("Prefixes = %v, want [2001:db8::/64]", scope.Prefixes)
+	}
+	if count := scope.Count(); count != 1<<16-2 {
+		t.Errorf("Count() = %d, want %d", count, 1<<16-2)
+	}
+}
+
+func TestScopeExclude(t *testing.T) {
+	scope, err := ParseScope(strings.NewReader("10.0.0.0/29 localhost 2001:db8::/64 2001:db8:1::/64"))
+	if err != nil {
+		t.Fatalf("ParseScope returned error: %v", err)
+	}
+	for _, entry := range []string{"10.0.0.2", "10.0.0.4-5", "localhost", "2001:db8::5", "2001:db8:1::/48"} {
+		if err := scope.Exclude(context.Background(), NewResolver("", 0, 0), entry); err != nil {
+			t.Fatalf("Exclude(%q) returned error: %v", entry, err)
+		}
+	}
+
+	hosts := []string{}
+	for target := range scope.Targets() {
+		hosts = append(hosts, target.Host)
+	}
+	if want := []string{"10.0.0.1", "10.0.0.3", "10.0.0.6"}; !slices.Equal(hosts, want) {
+		t.Errorf("Targets() = %v, want %v", hosts, want)
+	}
+	if count, excluded := scope.Count(), scope.ExcludedCount(); count != 3 || excluded != 4 {
+		t.Errorf("Count() = %d and ExcludedCount() = %d, want 3 and 4", count, excluded)
+	}
+
+	if len(scope.Prefixes) != 1 {
+		t.Errorf("Prefixes = %v, want the fully excluded prefix dropped", scope.Prefixes)
+	}
+	if prefixes := scope.SweepPrefixes(false); len(prefixes) != 0 {
+		t.Errorf("SweepPrefixes(false) = %v, want none", prefixes)
+	}
+	if prefixes := scope.SweepPrefixes(true); len(prefixes) != 1 {
+		t.Errorf("SweepPrefixes(true) = %v, want 1 prefix", prefixes)
+	}
+}
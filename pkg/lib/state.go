@@ -0,0 +1,119 @@
+package lib
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// StateFile records every finished host as a line of JSON so an interrupted
+// scan can be resumed without checking those hosts again.
+type StateFile struct {
+	mutex   sync.Mutex
+	file    *os.File
+	results []HostResult
+	done    map[string]bool
+	partial bool
+}
+
+// OpenStateFile opens the state file at path. With resume, the results already
+// in the file are loaded and new results are appended to them. Without resume
+// the file must not exist yet, so a finished scan is never overwritten by
+// accident.
+func OpenStateFile(path string, resume bool) (*StateFile, error) {
+	state := &StateFile{done: map[string]bool{}}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if resume {
+		if err := state.load(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+
+	file, err := os.OpenFile(path, flags, 0o644)
+	if errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("state file %s already exists, use --resume to continue it", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	state.file = file
+
+	if state.partial {
+		if _, err := file.WriteString("\n"); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	return state, nil
+}
+
+func (s *StateFile) load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var result HostResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			// the last line is cut short when copper is killed mid write, and
+			// that host is simply checked again
+			continue
+		}
+
+		if !s.done[result.Host] {
+			s.done[result.Host] = true
+			s.results = append(s.results, result)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	// start appending on a fresh line after a cut short write
+	info, err := file.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, info.Size()-1); err != nil {
+		return err
+	}
+	s.partial = last[0] != '\n'
+	return nil
+}
+
+// Results returns the results loaded from the state file when resuming.
+func (s *StateFile) Results() []HostResult {
+	return s.results
+}
+
+// Done reports whether host was finished by a previous run.
+func (s *StateFile) Done(host string) bool {
+	return s.done[host]
+}
+
+// Record appends result to the state file.
+func (s *StateFile) Record(result HostResult) error {
+	b, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, err = s.file.Write(append(b, '\n'))
+	return err
+}
+
+func (s *StateFile) Close() error {
+	return s.file.Close()
+}
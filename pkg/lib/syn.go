@@ -0,0 +1,154 @@
+package lib
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+)
+
+const (
+	tcpFlagSYN = 0x02
+	tcpFlagRST = 0x04
+	tcpFlagACK = 0x10
+)
+
+// Source ports for raw TCP probes are taken from above the kernel's default
+// ephemeral range so replies never collide with real connections.
+const (
+	synPortBase  = 61000
+	synPortCount = 65536 - synPortBase
+)
+
+// synResult is the reply a single SYN drew from a port.
+type synResult struct {
+	Port  int
+	State PortState
+	RTT   time.Duration
+}
+
+// synReply is a SYN-ACK or RST seen by the raw socket.
+type synReply struct {
+	port  int
+	flags uint8
+	ack   uint32
+	at    time.Time
+}
+
+// HostHasOpenPortSYN is HostHasOpenPort using the raw SYN engine. Every port
+// is sent a SYN at once and the first SYN-ACK or RST proves the host alive.
+func HostHasOpenPortSYN(ctx context.Context, scanner *SYNScanner, addr netip.Addr, ports []int, timeoutTCPMillis int) (Evidence, error) {
+	results, err := scanner.Probe(ctx, addr, ports, time.Duration(timeoutTCPMillis)*time.Millisecond, true)
+	if err != nil || len(results) == 0 {
+		return Evidence{Reason: ReasonNoResponse}, err
+	}
+
+	result := results[0]
+	reason := ReasonSynAck
+	if result.State == PortClosed {
+		reason = ReasonReset
+	}
+	return Evidence{Alive: true, Port: result.Port, Reason: reason, Latency: result.RTT}, nil
+}
+
+// scanTCPPortsSYN scans every port with the raw SYN engine. Ports that never
+// answer are filtered, unless ctx was cancelled before they had the chance.
+func scanTCPPortsSYN(ctx context.Context, addr netip.Addr, ports []int, opts ScanOptions) []PortResult {
+	replies := map[int]synResult{}
+	results, _ := opts.SYN.Probe(ctx, addr, ports, time.Duration(opts.RTT.TimeoutMillis(addr, opts.TimeoutTCPMillis))*time.Millisecond, false)
+	for _, result := range results {
+		replies[result.Port] = result
+		opts.RTT.Observe(addr, result.RTT)
+	}
+
+	portResults := []PortResult{}
+	for _, port := range uniquePorts(ports) {
+		reply, ok := replies[port]
+		if !ok && ctx.Err() != nil {
+			continue
+		}
+
+		state := PortFiltered
+		if ok {
+			state = reply.State
+		}
+		portResults = append(portResults, PortResult{port, "tcp", state, ServiceName("tcp", port)})
+	}
+	sortPortResults(portResults)
+	return portResults
+}
+
+// tcpSegment builds a bare TCP segment. SYNs carry an MSS option so they look
+// like any other connection attempt. The checksum is only filled in for IPv4,
+// the kernel computes it for IPv6 raw sockets.
+func tcpSegment(src, dst netip.Addr, srcPort, dstPort uint16, seq, ack uint32, flags uint8) []byte {
+	length := 20
+	if flags&tcpFlagSYN != 0 {
+		length = 24
+	}
+
+	segment := make([]byte, length)
+	binary.BigEndian.PutUint16(segment[0:], srcPort)
+	binary.BigEndian.PutUint16(segment[2:], dstPort)
+	binary.BigEndian.PutUint32(segment[4:], seq)
+	binary.BigEndian.PutUint32(segment[8:], ack)
+	segment[12] = byte(length/4) << 4 // data offset in 32 bit words
+	segment[13] = flags
+	binary.BigEndian.PutUint16(segment[14:], 1024) // window
+	if length > 20 {
+		segment[20], segment[21] = 2, 4 // MSS option
+		binary.BigEndian.PutUint16(segment[22:], 1460)
+	}
+
+	if dst.Is4() {
+		binary.BigEndian.PutUint16(segment[16:], tcpChecksum(src, dst, segment))
+	}
+	return segment
+}
+
+// tcpChecksum computes the IPv4 TCP checksum over the pseudo header and
+// segment.
+func tcpChecksum(src, dst netip.Addr, segment []byte) uint16 {
+	s, d := src.As4(), dst.As4()
+	pseudo := append(append(s[:], d[:]...), 0, 6, byte(len(segment)>>8), byte(len(segment)))
+	return ipChecksum(append(pseudo, segment...))
+}
+
+// ipChecksum is the internet checksum from RFC 1071.
+func ipChecksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// parseTCPReply pulls the ports, sequence and acknowledgement numbers and
+// flags out of a TCP segment.
+func parseTCPReply(segment []byte) (srcPort, dstPort uint16, seq, ack uint32, flags uint8, ok bool) {
+	if len(segment) < 20 {
+		return 0, 0, 0, 0, 0, false
+	}
+	return binary.BigEndian.Uint16(segment[0:]), binary.BigEndian.Uint16(segment[2:]),
+		binary.BigEndian.Uint32(segment[4:]), binary.BigEndian.Uint32(segment[8:]), segment[13], true
+}
+
+// sourceAddr returns the local address the kernel would route packets to dst
+// from. Connecting a UDP socket sends nothing.
+func sourceAddr(dst netip.Addr) (netip.Addr, error) {
+	conn, err := net.Dial("udp", netip.AddrPortFrom(dst, 9).String())
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("no route to %s: %w", dst, err)
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().(*net.UDPAddr).AddrPort().Addr().Unmap(), nil
+}
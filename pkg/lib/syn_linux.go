@@ -0,0 +1,257 @@
+//go:build linux
+
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/netip"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// SYNScanner sends crafted SYNs from one raw socket per address family and
+// matches the SYN-ACK and RST replies asynchronously, so thousands of ports
+// can be in flight without a socket or goroutine each. The kernel answers
+// every SYN-ACK with a RST since it has no matching connection, the same as a
+// half open nmap scan. Pacer, when set, paces every SYN sent.
+type SYNScanner struct {
+	fd4   int
+	fd6   int
+	pacer *Pacer
+
+	mutex    sync.Mutex
+	pending  map[synKey]chan synReply
+	nextPort uint32
+
+	closed    atomic.Bool
+	receivers sync.WaitGroup
+}
+
+// synKey identifies the replies to one Probe by the remote address and the
+// local source port the SYNs were sent from.
+type synKey struct {
+	addr netip.Addr
+	port uint16
+}
+
+// NewSYNScanner opens the raw sockets, which needs root or CAP_NET_RAW.
+func NewSYNScanner(pacer *Pacer) (*SYNScanner, error) {
+	fd4, err := unix.Socket(unix.AF_INET, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.IPPROTO_TCP)
+	if err != nil {
+		return nil, fmt.Errorf("SYN mode needs root or CAP_NET_RAW: %w", err)
+	}
+
+	// IPv6 is optional, and the kernel fills in its checksums for us
+	fd6, err := unix.Socket(unix.AF_INET6, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.IPPROTO_TCP)
+	if err == nil {
+		if err := unix.SetsockoptInt(fd6, unix.IPPROTO_IPV6, unix.IPV6_CHECKSUM, 16); err != nil {
+			unix.Close(fd6)
+			fd6 = -1
+		}
+	} else {
+		fd6 = -1
+	}
+
+	s := &SYNScanner{
+		fd4:      fd4,
+		fd6:      fd6,
+		pacer:    pacer,
+		pending:  map[synKey]chan synReply{},
+		nextPort: rand.Uint32N(synPortCount),
+	}
+
+	for _, fd := range []int{fd4, fd6} {
+		if fd < 0 {
+			continue
+		}
+
+		tv := unix.NsecToTimeval((100 * time.Millisecond).Nanoseconds())
+		if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+			s.Close()
+			return nil, err
+		}
+
+		s.receivers.Add(1)
+		go s.receive(fd, fd == fd4)
+	}
+
+	return s, nil
+}
+
+// Probe sends a SYN to every port on addr and collects the replies until
+// timeout has passed since the last SYN went out. With first set it returns
+// as soon as any port answers. Ports that don't answer are left out of the
+// results, which are sorted by port.
+func (s *SYNScanner) Probe(ctx context.Context, addr netip.Addr, ports []int, timeout time.Duration, first bool) ([]synResult, error) {
+	addr = addr.Unmap()
+	fd := s.fd4
+	if addr.Is6() {
+		if s.fd6 < 0 {
+			return nil, errors.New("SYN mode is not available for IPv6")
+		}
+		fd = s.fd6
+	}
+
+	src, err := sourceAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	key := synKey{addr, uint16(synPortBase + atomic.AddUint32(&s.nextPort, 1)%synPortCount)}
+	replies := make(chan synReply, len(ports))
+	s.mutex.Lock()
+	s.pending[key] = replies
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.pending, key)
+		s.mutex.Unlock()
+	}()
+
+	seq := rand.Uint32()
+	sent := map[int]time.Time{}
+	results := map[int]synResult{}
+	record := func(reply synReply) {
+		start, ok := sent[reply.port]
+		if !ok || reply.ack != seq+1 {
+			return
+		}
+		if _, seen := results[reply.port]; seen {
+			return
+		}
+
+		state := PortClosed
+		if reply.flags&tcpFlagSYN != 0 {
+			state = PortOpen
+		}
+		results[reply.port] = synResult{reply.port, state, reply.at.Sub(start)}
+	}
+
+	var sendErr error
+	for _, port := range ports {
+		if _, ok := sent[port]; ok {
+			continue
+		}
+		if sendErr = s.pacer.Wait(ctx, addr); sendErr != nil {
+			break
+		}
+
+		sent[port] = time.Now()
+		segment := tcpSegment(src, addr, key.port, uint16(port), seq, 0, tcpFlagSYN)
+		if sendErr = unix.Sendto(fd, segment, 0, synSockaddr(addr)); sendErr != nil {
+			break
+		}
+
+		for drained := false; !drained; {
+			select {
+			case reply := <-replies:
+				record(reply)
+			default:
+				drained = true
+			}
+		}
+		if first && len(results) > 0 {
+			return sortedSYNResults(results), nil
+		}
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for len(results) < len(sent) && !(first && len(results) > 0) {
+		select {
+		case reply := <-replies:
+			record(reply)
+		case <-deadline.C:
+			return sortedSYNResults(results), sendErr
+		case <-ctx.Done():
+			return sortedSYNResults(results), ctx.Err()
+		}
+	}
+
+	return sortedSYNResults(results), sendErr
+}
+
+func (s *SYNScanner) receive(fd int, ipv4 bool) {
+	defer s.receivers.Done()
+
+	buf := make([]byte, 65536)
+	for !s.closed.Load() {
+		n, from, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			continue
+		}
+		at := time.Now()
+
+		var addr netip.Addr
+		switch from := from.(type) {
+		case *unix.SockaddrInet4:
+			addr = netip.AddrFrom4(from.Addr)
+		case *unix.SockaddrInet6:
+			addr = netip.AddrFrom16(from.Addr)
+		default:
+			continue
+		}
+
+		segment := buf[:n]
+		if ipv4 {
+			// IPv4 raw sockets hand over the IP header as well
+			headerLength := int(segment[0]&0x0f) * 4
+			if n < headerLength {
+				continue
+			}
+			segment = segment[headerLength:]
+		}
+
+		srcPort, dstPort, _, ack, flags, ok := parseTCPReply(segment)
+		if !ok || flags&(tcpFlagACK|tcpFlagRST) == 0 {
+			continue
+		}
+
+		s.mutex.Lock()
+		replies := s.pending[synKey{addr, dstPort}]
+		s.mutex.Unlock()
+		if replies == nil {
+			continue
+		}
+
+		select {
+		case replies <- synReply{int(srcPort), flags, ack, at}:
+		default:
+		}
+	}
+}
+
+func (s *SYNScanner) Close() error {
+	s.closed.Store(true)
+	s.receivers.Wait()
+
+	if s.fd6 >= 0 {
+		unix.Close(s.fd6)
+	}
+	return unix.Close(s.fd4)
+}
+
+func synSockaddr(addr netip.Addr) unix.Sockaddr {
+	if addr.Is4() {
+		return &unix.SockaddrInet4{Addr: addr.As4()}
+	}
+	return &unix.SockaddrInet6{Addr: addr.As16()}
+}
+
+func sortedSYNResults(results map[int]synResult) []synResult {
+	sorted := make([]synResult, 0, len(results))
+	for _, result := range results {
+		sorted = append(sorted, result)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Port < sorted[j].Port
+	})
+	return sorted
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+package lib
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"time"
+)
+
+// SYNScanner is only implemented on linux.
+type SYNScanner struct{}
+
+func NewSYNScanner(pacer *Pacer) (*SYNScanner, error) {
+	return nil, errors.New("SYN mode is only supported on linux")
+}
+
+func (s *SYNScanner) Probe(ctx context.Context, addr netip.Addr, ports []int, timeout time.Duration, first bool) ([]synResult, error) {
+	return nil, errors.New("SYN mode is only supported on linux")
+}
+
+func (s *SYNScanner) Close() error {
+	return nil
+}
@@ -0,0 +1,196 @@
+package lib
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+// udpPayloads holds protocol-appropriate probes for well known UDP services.
+// Most UDP services ignore empty datagrams, so a valid request is the only way
+// to get them to answer. Ports without a payload are sent an empty datagram,
+// which is still enough to elicit an ICMP port unreachable from a live host.
+var udpPayloads = map[int][]byte{
+	// DNS: standard query for the root NS records.
+	53: {
+		0x13, 0x37, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x02, 0x00, 0x01,
+	},
+	// TFTP: read request for a file that almost certainly does not exist.
+	69: append(append([]byte{0x00, 0x01}, "copper.txt\x00"...), "octet\x00"...),
+	// Portmapper: RPC call to the NULL procedure of portmap v2.
+	111: {
+		0x72, 0xfe, 0x1d, 0x13, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02,
+		0x00, 0x01, 0x86, 0xa0, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+	},
+	// NTP: version 4 client request.
+	123: append([]byte{0xe3}, make([]byte, 47)...),
+	// NetBIOS: node status request for the wildcard name.
+	137: append(append([]byte{
+		0x80, 0xf0, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x20,
+	}, "CKAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"...), 0x00, 0x00, 0x21, 0x00, 0x01),
+	// SNMP: v1 get-request for sysDescr.0 with the "public" community.
+	161: {
+		0x30, 0x29, 0x02, 0x01, 0x00, 0x04, 0x06, 'p', 'u', 'b', 'l', 'i', 'c',
+		0xa0, 0x1c, 0x02, 0x04, 0x71, 0x68, 0xe5, 0x1d, 0x02, 0x01, 0x00, 0x02,
+		0x01, 0x00, 0x30, 0x0e, 0x30, 0x0c, 0x06, 0x08, 0x2b, 0x06, 0x01, 0x02,
+		0x01, 0x01, 0x01, 0x00, 0x05, 0x00,
+	},
+	// IKE: ISAKMP main mode with a single common proposal.
+	500: ikeMainModePayload(),
+	// RIP: v2 request for the whole routing table.
+	520: append([]byte{0x01, 0x02, 0x00, 0x00}, append(make([]byte, 16), 0x00, 0x00, 0x00, 0x10)...),
+	// MS SQL browser: enumerate instances.
+	1434: {0x02},
+	// SSDP: search for all devices.
+	1900: []byte("M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: 239.255.255.250:1900\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 1\r\n" +
+		"ST: ssdp:all\r\n\r\n"),
+	// IKE NAT traversal: non-ESP marker followed by the IKE probe.
+	4500: append([]byte{0x00, 0x00, 0x00, 0x00}, ikeMainModePayload()...),
+	// mDNS: DNS-SD service enumeration.
+	5353: append(append([]byte{
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x09,
+	}, "_services\x07_dns-sd\x04_udp\x05local\x00"...), 0x00, 0x0c, 0x00, 0x01),
+	// memcached: stats command with the UDP frame header.
+	11211: append([]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00}, "stats\r\n"...),
+}
+
+func ikeMainModePayload() []byte {
+	attributes := [][2]uint16{
+		{1, 5},      // encryption: 3DES
+		{2, 2},      // hash: SHA1
+		{3, 1},      // authentication: pre-shared key
+		{4, 2},      // group: MODP 1024
+		{11, 1},     // life type: seconds
+		{12, 28800}, // life duration
+	}
+
+	transform := []byte{0x00, 0x00, 0x00, 0x00, 0x01, 0x01, 0x00, 0x00}
+	for _, attribute := range attributes {
+		transform = binary.BigEndian.AppendUint16(transform, 0x8000|attribute[0])
+		transform = binary.BigEndian.AppendUint16(transform, attribute[1])
+	}
+	binary.BigEndian.PutUint16(transform[2:], uint16(len(transform)))
+
+	proposal := append([]byte{0x00, 0x00, 0x00, 0x00, 0x01, 0x01, 0x00, 0x01}, transform...)
+	binary.BigEndian.PutUint16(proposal[2:], uint16(len(proposal)))
+
+	sa := append([]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01}, proposal...)
+	binary.BigEndian.PutUint16(sa[2:], uint16(len(sa)))
+
+	header := []byte{
+		0x43, 0x6f, 0x70, 0x70, 0x65, 0x72, 0x21, 0x21, // initiator cookie
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // responder cookie
+		0x01, 0x10, 0x02, 0x00, // SA payload, v1.0, main mode, no flags
+		0x00, 0x00, 0x00, 0x00, // message ID
+		0x00, 0x00, 0x00, 0x00, // length
+	}
+	binary.BigEndian.PutUint32(header[24:], uint32(len(header)+len(sa)))
+
+	return append(header, sa...)
+}
+
+// UDPPayload returns the probe payload copper sends to a UDP port.
+func UDPPayload(port int) []byte {
+	return udpPayloads[port]
+}
+
+// HostRespondsToUDP sends a probe to every port at once and waits up to the
+// timeout for any of them to answer. A UDP reply or an ICMP port unreachable
+// both prove the host is alive. Cancelling ctx stops waiting straight away.
+func HostRespondsToUDP(ctx context.Context, host string, ports []int, timeoutUDPMillis int) Evidence {
+	timeout := time.Duration(timeoutUDPMillis) * time.Millisecond
+	evidence := make(chan Evidence, len(ports))
+	conns := []net.Conn{}
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	d := net.Dialer{Timeout: timeout}
+	for _, port := range ports {
+		conn, err := d.DialContext(ctx, "udp", net.JoinHostPort(host, fmt.Sprint(port)))
+		if err != nil {
+			if state, reason := classifyDialError(err); state == PortUnreachable {
+				return Evidence{Reason: reason}
+			}
+			continue
+		}
+		conns = append(conns, conn)
+
+		go func(conn net.Conn, port int) {
+			evidence <- sendUDPProbe(conn, port, timeout)
+		}(conn, port)
+	}
+
+	result := Evidence{Reason: ReasonNoResponse}
+	for range conns {
+		var e Evidence
+		select {
+		case e = <-evidence:
+		case <-ctx.Done():
+			return result
+		}
+
+		if e.Alive {
+			return e
+		}
+		if e.Reason != ReasonNoResponse {
+			result = e
+		}
+	}
+
+	return result
+}
+
+func sendUDPProbe(conn net.Conn, port int, timeout time.Duration) Evidence {
+	start := time.Now()
+	conn.SetDeadline(start.Add(timeout))
+
+	_, err := conn.Write(UDPPayload(port))
+	if err == nil {
+		buf := make([]byte, 1500)
+		_, err = conn.Read(buf)
+	}
+	latency := time.Since(start)
+
+	state, reason := classifyDialError(err)
+	switch state {
+	case PortOpen:
+		return Evidence{Alive: true, Port: port, Reason: ReasonUDPResponse, Latency: latency}
+	case PortClosed:
+		// ICMP port unreachable surfaces as a refused connection
+		return Evidence{Alive: true, Port: port, Reason: ReasonPortUnreachable, Latency: latency}
+	case PortUnreachable:
+		return Evidence{Port: port, Reason: reason}
+	}
+
+	return Evidence{Reason: ReasonNoResponse}
+}
+
+type udpProber struct {
+	timeoutMillis int
+	ports         []int
+}
+
+func (p *udpProber) Name() string {
+	return MethodUDP
+}
+
+func (p *udpProber) Cost() int {
+	return 2
+}
+
+func (p *udpProber) Probe(ctx context.Context, target Target) (Evidence, error) {
+	return HostRespondsToUDP(ctx, target.Host, p.ports, p.timeoutMillis), nil
+}
@@ -0,0 +1,74 @@
+package lib
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"iter"
+	"strings"
+)
+
+// addWildcard records a *.domain entry. Wildcards can't be probed directly and
+// are only added to the scope by ExpandWildcards.
+func (s *Scope) addWildcard(entry string) error {
+	domain, ok := strings.CutPrefix(entry, "*.")
+	if !ok || strings.Contains(domain, "*") || !isHostname(domain) {
+		return errors.New("wildcards are only supported as *.domain")
+	}
+
+	s.Wildcards = append(s.Wildcards, strings.ToLower(strings.TrimSuffix(domain, ".")))
+	return nil
+}
+
+// ExpandWildcards prefixes every wildcard domain in the scope with each word
+// and resolves the candidates, adding every address that resolves to the scope
+// linked to its name. When a domain has wildcard DNS, candidates that only
+// resolve to the wildcard's addresses are dropped. It returns how many
+// addresses were added for each domain.
+func (s *Scope) ExpandWildcards(ctx context.Context, resolver *Resolver, words []string) map[string]int {
+	added := map[string]int{}
+	for _, domain := range s.Wildcards {
+		catchAll := map[string]bool{}
+		for target, err := range resolver.Resolve(ctx, wildcardCandidates(domain, []string{randomLabel()})) {
+			if err == nil {
+				catchAll[target.Addr.String()] = true
+			}
+		}
+
+		for target, err := range resolver.Resolve(ctx, wildcardCandidates(domain, words)) {
+			if err != nil || catchAll[target.Addr.String()] {
+				continue
+			}
+
+			s.entries = append(s.entries, scopeEntry{host: target.Host, name: target.Name})
+			added[domain]++
+		}
+	}
+	return added
+}
+
+func wildcardCandidates(domain string, words []string) iter.Seq[Target] {
+	return func(yield func(Target) bool) {
+		seen := map[string]bool{}
+		for _, word := range words {
+			host := strings.ToLower(strings.Trim(word, ".")) + "." + domain
+			if seen[host] || !isHostname(host) {
+				continue
+			}
+			seen[host] = true
+
+			if !yield(Target{Host: host}) {
+				return
+			}
+		}
+	}
+}
+
+// randomLabel returns a subdomain label that should never exist, used to
+// detect wildcard DNS.
+func randomLabel() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "copper-" + hex.EncodeToString(b)
+}